@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// CapturedSQL is a statement as it would have been sent to MO.
+type CapturedSQL struct {
+	SQL  string
+	Args []any
+}
+
+// String prints the arguments as a comment before the statement, times in UTC.
+func (c CapturedSQL) String() string {
+	var b strings.Builder
+	if len(c.Args) > 0 {
+		args := make([]string, len(c.Args))
+		for i, a := range c.Args {
+			switch v := a.(type) {
+			case time.Time:
+				args[i] = v.UTC().Format(time.RFC3339Nano)
+			case string:
+				args[i] = fmt.Sprintf("%q", v)
+			case nil:
+				args[i] = "NULL"
+			default:
+				args[i] = fmt.Sprint(v)
+			}
+		}
+		b.WriteString("-- args: " + strings.Join(args, ", ") + "\n")
+	}
+	b.WriteString(strings.TrimSpace(c.SQL) + ";\n")
+	return b.String()
+}
+
+// SQLCapture is a dry run of the query layer: its DB sends every statement to a recorder
+// instead of MO, and the queries return no rows. gorm's DryRun is not enough, the query
+// layer builds its SQL with ToSQL, which already runs in DryRun, and executes it with
+// Scan, which fails in DryRun.
+type SQLCapture struct {
+	db *gorm.DB
+
+	mu    sync.Mutex
+	stmts []CapturedSQL
+}
+
+func NewSQLCapture() (*SQLCapture, error) {
+	c := &SQLCapture{}
+	db, err := gorm.Open(gmysql.New(gmysql.Config{
+		Conn:                      sql.OpenDB(captureConnector{c}),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{
+			SingularTable: true,
+		},
+		// ToSQL logs the failed Scan it runs in DryRun
+		Logger:                                   gormlogger.Discard,
+		DisableAutomaticPing:                     true,
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.db = db
+	return c, nil
+}
+
+func (c *SQLCapture) DB() *gorm.DB {
+	return c.db
+}
+
+// Take returns the statements captured since the last Take. They are sorted since the
+// query layer runs its statements concurrently.
+func (c *SQLCapture) Take() []CapturedSQL {
+	c.mu.Lock()
+	stmts := c.stmts
+	c.stmts = nil
+	c.mu.Unlock()
+	sort.SliceStable(stmts, func(i, j int) bool { return stmts[i].String() < stmts[j].String() })
+	return stmts
+}
+
+func (c *SQLCapture) record(query string, args []driver.NamedValue) {
+	stmt := CapturedSQL{SQL: query}
+	for _, a := range args {
+		stmt.Args = append(stmt.Args, a.Value)
+	}
+	c.mu.Lock()
+	c.stmts = append(c.stmts, stmt)
+	c.mu.Unlock()
+}
+
+// captureConnector and the types below are a database/sql driver over SQLCapture.
+type captureConnector struct {
+	c *SQLCapture
+}
+
+func (cc captureConnector) Connect(context.Context) (driver.Conn, error) {
+	return captureConn(cc), nil
+}
+
+func (cc captureConnector) Driver() driver.Driver {
+	return captureDriver{}
+}
+
+type captureDriver struct{}
+
+func (captureDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("capture driver is only used through its connector")
+}
+
+type captureConn struct {
+	c *SQLCapture
+}
+
+func (cc captureConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("capture driver does not prepare: %s", query)
+}
+
+func (cc captureConn) Close() error {
+	return nil
+}
+
+func (cc captureConn) Begin() (driver.Tx, error) {
+	return captureTx{}, nil
+}
+
+// CheckNamedValue keeps the arguments as they are, the recorder prints them.
+func (cc captureConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (cc captureConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	cc.c.record(query, args)
+	return captureRows{}, nil
+}
+
+func (cc captureConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	cc.c.record(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+type captureTx struct{}
+
+func (captureTx) Commit() error   { return nil }
+func (captureTx) Rollback() error { return nil }
+
+type captureRows struct{}
+
+func (captureRows) Columns() []string         { return nil }
+func (captureRows) Close() error              { return nil }
+func (captureRows) Next([]driver.Value) error { return io.EOF }
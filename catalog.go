@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+type MetricKind string
+
+const (
+	MetricCounter   MetricKind = "counter"
+	MetricGauge     MetricKind = "gauge"
+	MetricHistogram MetricKind = "histogram"
+)
+
+// catalogScanLimit bounds the concurrent min/max(collecttime) queries.
+const catalogScanLimit = 4
+
+type MetricInfo struct {
+	Name       string     `json:"name"`
+	Kind       MetricKind `json:"kind"`
+	Labels     []string   `json:"labels"`
+	EarliestAt *time.Time `json:"earliest_at,omitempty"`
+	LatestAt   *time.Time `json:"latest_at,omitempty"`
+	// ApproxRows comes from information_schema.tables.
+	ApproxRows int64 `json:"approx_rows"`
+}
+
+type metricTableRow struct {
+	TableName string
+	TableRows int64
+}
+
+type metricColumnRow struct {
+	TableName  string
+	ColumnName string
+}
+
+// guessMetricKind follows the Prometheus naming conventions, MO keeps them for its metrics.
+func guessMetricKind(name string, labels []string) MetricKind {
+	switch {
+	case strings.HasSuffix(name, "_bucket") || containsLabel(labels, histogramLeLabel):
+		return MetricHistogram
+	case strings.HasSuffix(name, "_total"), strings.HasSuffix(name, "_count"), strings.HasSuffix(name, "_sum"):
+		return MetricCounter
+	default:
+		return MetricGauge
+	}
+}
+
+// SelectMetricCatalog lists the metric tables of system_metrics with their labels.
+// withTimeRange also reads min and max collecttime of every table, which scans them.
+func SelectMetricCatalog(db *gorm.DB, withTimeRange bool, sqlComment string) ([]MetricInfo, error) {
+	var (
+		tables  []metricTableRow
+		columns []metricColumnRow
+		eg      errgroup.Group
+	)
+	eg.Go(func() error {
+		return db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("information_schema.tables").
+			Select("table_name, table_rows").Where("table_schema = ?", metricsDatabase).
+			Order("table_name").Scan(&tables).Error
+	})
+	eg.Go(func() error {
+		return db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("information_schema.columns").
+			Select("table_name, column_name").Where("table_schema = ?", metricsDatabase).
+			Order("table_name, ordinal_position").Scan(&columns).Error
+	})
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string][]string)
+	for _, c := range columns {
+		if c.ColumnName != metricTimeCol && c.ColumnName != metricValueCol {
+			labels[c.TableName] = append(labels[c.TableName], c.ColumnName)
+		}
+	}
+	catalog := make([]MetricInfo, 0, len(tables))
+	for _, t := range tables {
+		ls := labels[t.TableName]
+		if ls == nil {
+			ls = []string{}
+		}
+		catalog = append(catalog, MetricInfo{
+			Name:       t.TableName,
+			Kind:       guessMetricKind(t.TableName, ls),
+			Labels:     ls,
+			ApproxRows: t.TableRows,
+		})
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].Name < catalog[j].Name })
+	if !withTimeRange {
+		return catalog, nil
+	}
+
+	var scan errgroup.Group
+	scan.SetLimit(catalogScanLimit)
+	for i := range catalog {
+		info := &catalog[i]
+		if !identRegexp.MatchString(info.Name) {
+			continue
+		}
+		scan.Go(func() error {
+			var earliest, latest sql.NullTime
+			row := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(metricsDatabase + "." + info.Name).
+				Select("min(`" + metricTimeCol + "`), max(`" + metricTimeCol + "`)").Row()
+			if err := row.Scan(&earliest, &latest); err != nil {
+				return err
+			}
+			if earliest.Valid {
+				info.EarliestAt = &earliest.Time
+			}
+			if latest.Valid {
+				info.LatestAt = &latest.Time
+			}
+			return nil
+		})
+	}
+	if err := scan.Wait(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
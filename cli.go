@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
+)
+
+var errUsage = errors.New("usage")
+
+// command is one node of the cli, either runnable or a group of subcommands.
+type command struct {
+	name  string
+	args  string
+	short string
+	flags func(fs *flag.FlagSet)
+	run   func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error
+	subs  []*command
+}
+
+// cliEnv holds the global flags and the lazily opened connection.
+type cliEnv struct {
+	configPath string
+	profile    string
+	verbose    bool
+	out        io.Writer
+	render     RenderOptions
+
+	cfg *Config
+	db  *gorm.DB
+}
+
+func (e *cliEnv) config() (*Config, error) {
+	if e.cfg != nil {
+		return e.cfg, nil
+	}
+	profiles, err := LoadProfiles(e.configPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := profiles.Get(e.profile)
+	if err != nil {
+		return nil, err
+	}
+	e.cfg = &cfg
+	return e.cfg, nil
+}
+
+func (e *cliEnv) location() (*time.Location, error) {
+	cfg, err := e.config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Location()
+}
+
+// open connects with the selected profile on first use.
+func (e *cliEnv) open(ctx context.Context) (*gorm.DB, error) {
+	if e.db != nil {
+		return e.db.WithContext(ctx), nil
+	}
+	cfg, err := e.config()
+	if err != nil {
+		return nil, err
+	}
+	logger = NewLogger(newCLIZapLogger(e.verbose))
+	db, err := OpenDB(*cfg, logger, InterpolateParams(true))
+	if err != nil {
+		return nil, err
+	}
+	e.db = db
+	return db.WithContext(ctx), nil
+}
+
+func (e *cliEnv) close() {
+	if e.db == nil {
+		return
+	}
+	if conn, err := e.db.DB(); err == nil {
+		conn.Close()
+	}
+}
+
+// parseRange resolves a -range flag in the time zone of the profile.
+func (e *cliEnv) parseRange(s string) (time.Time, time.Time, error) {
+	loc, err := e.location()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	r, err := ParseTimeRange(s, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	start, end, err := r.Resolve(time.Now())
+	return start.In(loc), end.In(loc), err
+}
+
+func (e *cliEnv) print(v any) error {
+	return Render(e.out, v, e.render)
+}
+
+// newCLIZapLogger logs to stderr so results on stdout stay clean.
+func newCLIZapLogger(verbose bool) *zap.Logger {
+	level := zap.ErrorLevel
+	if verbose {
+		level = zap.DebugLevel
+	}
+	encoderCfg := zapcore.EncoderConfig{
+		MessageKey:     "msg",
+		LevelKey:       "level",
+		TimeKey:        "ts",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+	}
+	return zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg), os.Stderr, level))
+}
+
+// stringsFlag collects a repeatable flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// splitList splits a comma separated flag value, empty gives nil.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func cliCommands() *command {
+	return &command{
+		name: "gorm_demo",
+		subs: []*command{
+			pingCommand(),
+			historyCommand(),
+			metricsCommand(),
+			runningCommand(),
+			killCommand(),
+			locksCommand(),
+			sqlCommand(),
+			reproCommand(),
+			genCommand(),
+		},
+	}
+}
+
+// runCLI parses the global flags and runs the selected subcommand.
+func runCLI(args []string) error {
+	env := &cliEnv{out: os.Stdout}
+	root := cliCommands()
+	fs := flag.NewFlagSet(root.name, flag.ContinueOnError)
+	fs.StringVar(&env.configPath, "config", "config.yaml", "config file with the connection profiles")
+	fs.StringVar(&env.profile, "profile", defaultProfile, "profile to connect with")
+	fs.BoolVar(&env.verbose, "v", false, "log every sql")
+	fs.StringVar((*string)(&env.render.Format), "output", string(OutputTable), "table, json, ndjson, csv, yaml or markdown")
+	fs.IntVar(&env.render.StatementWidth, "width", defaultStatementWidth, "truncate the statements of table and markdown output")
+	fs.BoolVar(&env.render.Raw, "raw", false, "print nanoseconds and bytes as numbers in table and markdown output")
+	fs.Usage = func() { printUsage(fs.Output(), root, nil, fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	defer env.close()
+	return root.dispatch(ctx, env, nil, fs.Args())
+}
+
+func (c *command) dispatch(ctx context.Context, env *cliEnv, path []string, args []string) error {
+	path = append(path, c.name)
+	if len(c.subs) > 0 {
+		if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			printUsage(os.Stderr, c, path[:len(path)-1], nil)
+			return errUsage
+		}
+		for _, sub := range c.subs {
+			if sub.name == args[0] {
+				return sub.dispatch(ctx, env, path, args[1:])
+			}
+		}
+		printUsage(os.Stderr, c, path[:len(path)-1], nil)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	if c.flags != nil {
+		c.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] %s\n  %s\n", strings.Join(path, " "), c.args, c.short)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return c.run(ctx, env, fs)
+}
+
+func printUsage(w io.Writer, c *command, parents []string, global *flag.FlagSet) {
+	name := strings.Join(append(append([]string{}, parents...), c.name), " ")
+	fmt.Fprintf(w, "Usage: %s <command>\n\nCommands:\n", name)
+	subs := append([]*command{}, c.subs...)
+	sort.Slice(subs, func(i, j int) bool { return subs[i].name < subs[j].name })
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, sub := range subs {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", sub.name, sub.args, sub.short)
+	}
+	tw.Flush()
+	if global != nil {
+		fmt.Fprintf(w, "\nGlobal flags:\n")
+		global.PrintDefaults()
+	}
+}
+
+func pingCommand() *command {
+	return &command{
+		name:  "ping",
+		short: "connect with the profile and print the server version",
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			begin := time.Now()
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			var version string
+			if err := db.Raw(NonUserComment + " select version()").Scan(&version).Error; err != nil {
+				return err
+			}
+			cfg, _ := env.config()
+			fmt.Fprintf(env.out, "%s@%s:%d version=%s elapsed=%s\n", cfg.Username, cfg.Host, cfg.Port, version, time.Since(begin))
+			return nil
+		},
+	}
+}
+
+func runningCommand() *command {
+	var account string
+	return &command{
+		name:  "running",
+		short: "list the statements of an account running right now",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&account, "account", "", "account of the statements")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			records, err := StatementInfo{Account: account}.SelectRunning(db, NonUserRawComment)
+			if err != nil {
+				return err
+			}
+			return env.print(records)
+		},
+	}
+}
+
+func killCommand() *command {
+	var statementID, sessionID string
+	return &command{
+		name:  "kill",
+		short: "cancel a running statement or close a session of the account of the profile",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&statementID, "statement", "", "statement id to cancel")
+			fs.StringVar(&sessionID, "session", "", "session id to close")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			if (statementID == "") == (sessionID == "") {
+				return errors.New("one of -statement and -session is required")
+			}
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			si := StatementInfo{StatementId: statementID, SessionId: sessionID}
+			if statementID != "" {
+				err = si.KillStatement(db, NonUserRawComment)
+			} else {
+				err = si.KillSession(db, NonUserRawComment)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(env.out, "killed")
+			return nil
+		},
+	}
+}
+
+// reproCommand runs a yaml scenario, or one of the hardcoded repro scenarios which
+// connect to 127.0.0.1:6001 by themselves.
+func reproCommand() *command {
+	scenarios := map[string]func(){
+		"context-timeout": testContextTimeout,
+		"null-text":       testNullText,
+		"account":         testAccount,
+	}
+	names := make([]string, 0, len(scenarios))
+	for name := range scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &command{
+		name:  "repro",
+		args:  "<scenario.yaml|" + strings.Join(names, "|") + ">",
+		short: "run a yaml scenario or one of the repro scenarios",
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			if fs.NArg() != 1 {
+				fs.Usage()
+				return errUsage
+			}
+			if run, ok := scenarios[fs.Arg(0)]; ok {
+				run()
+				return nil
+			}
+			return runScenarioFile(ctx, env, fs.Arg(0))
+		},
+	}
+}
+
+// runScenarioFile runs the scenario at path with the selected profile as its connection,
+// the config file is optional when the scenario has its own.
+func runScenarioFile(ctx context.Context, env *cliEnv, path string) error {
+	sc, err := LoadScenario(path)
+	if err != nil {
+		return err
+	}
+	runner := &ScenarioRunner{Logger: NewLogger(newCLIZapLogger(env.verbose))}
+	if profiles, err := LoadProfiles(env.configPath); err == nil {
+		runner.Profiles = profiles
+		if cfg, err := profiles.Get(env.profile); err == nil {
+			runner.Base = cfg
+		}
+	} else if sc.Connection.Host == "" {
+		return err
+	}
+
+	result, err := runner.Run(ctx, sc)
+	if err != nil {
+		return err
+	}
+	if err := env.print(result.Steps); err != nil {
+		return err
+	}
+	if !result.Passed {
+		return fmt.Errorf("scenario %s: FAIL", sc.Name)
+	}
+	fmt.Fprintf(os.Stderr, "scenario %s: PASS\n", sc.Name)
+	return nil
+}
+
+func genCommand() *command {
+	var (
+		opts          = DefaultStatementGenOptions()
+		timeRange     string
+		statsVersions string
+		batchSize     int
+		output        string
+		format        string
+	)
+	return &command{
+		name:  "gen",
+		short: "generate synthetic statement_info and statement_cu rows into the profile or files",
+		flags: func(fs *flag.FlagSet) {
+			fs.Int64Var(&opts.Seed, "seed", opts.Seed, "seed, the same flags give the same rows")
+			fs.IntVar(&opts.Rows, "rows", opts.Rows, "number of statements")
+			fs.StringVar(&timeRange, "range", "last 1h", "request_at range, like \"last 1h\" or start/end")
+			fs.IntVar(&opts.Accounts, "accounts", opts.Accounts, "number of accounts, the first is sys")
+			fs.IntVar(&opts.UsersPerAccount, "users", opts.UsersPerAccount, "users per account")
+			fs.IntVar(&opts.Fingerprints, "fingerprints", opts.Fingerprints, "number of fingerprints")
+			fs.IntVar(&opts.Nodes, "nodes", opts.Nodes, "number of CN nodes")
+			fs.Float64Var(&opts.Skew, "skew", opts.Skew, "zipf s of fingerprints, accounts and users, > 1")
+			fs.Float64Var(&opts.ErrorRate, "error-rate", opts.ErrorRate, "share of Failed statements")
+			fs.Float64Var(&opts.RunningRate, "running-rate", opts.RunningRate, "share of Running statements")
+			fs.StringVar(&statsVersions, "stats-versions", "1,2,3,4", "comma separated versions of the stats arrays")
+			fs.IntVar(&batchSize, "batch", defaultGenBatchSize, "rows per insert")
+			fs.StringVar(&output, "o", "", "directory to write statement_info and statement_cu files to instead of inserting")
+			fs.StringVar(&format, "format", string(ExportJSONL), "csv or jsonl, with -o")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			// files need no profile, their range is in UTC without one
+			loc, err := env.location()
+			if err != nil && output == "" {
+				return err
+			} else if err != nil {
+				loc = time.UTC
+			}
+			r, err := ParseTimeRange(timeRange, loc)
+			if err != nil {
+				return err
+			}
+			if opts.Start, opts.End, err = r.Resolve(time.Now()); err != nil {
+				return err
+			}
+			opts.StatsVersions = nil
+			for _, v := range splitList(statsVersions) {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return fmt.Errorf("invalid stats version %q", v)
+				}
+				opts.StatsVersions = append(opts.StatsVersions, n)
+			}
+			g, err := NewStatementGenerator(opts)
+			if err != nil {
+				return err
+			}
+
+			var done GenProgress
+			if output != "" {
+				if err := os.MkdirAll(output, 0o755); err != nil {
+					return err
+				}
+				files := make([]*os.File, 0, 2)
+				defer func() {
+					for _, f := range files {
+						f.Close()
+					}
+				}()
+				for _, name := range []string{"statement_info", "statement_cu"} {
+					f, err := os.Create(filepath.Join(output, name+"."+format))
+					if err != nil {
+						return err
+					}
+					files = append(files, f)
+				}
+				if done, err = GenerateToFiles(files[0], files[1], ExportFormat(format), g); err != nil {
+					return err
+				}
+			} else {
+				db, err := env.open(ctx)
+				if err != nil {
+					return err
+				}
+				done, err = GenerateToDB(ctx, db, g, batchSize, func(p GenProgress) {
+					fmt.Fprintf(os.Stderr, "inserted %d statements, %d cu\n", p.Statements, p.CU)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(os.Stderr, "done, %d statements, %d cu, %s/%s\n", done.Statements, done.CU, opts.Start, opts.End)
+			return nil
+		},
+	}
+}
+
+func sqlCommand() *command {
+	var execute string
+	return &command{
+		name:  "sql",
+		short: "interactive SQL shell, or run the statements of -e",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&execute, "e", "", "statements separated by ; to run instead of the shell")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			// the shell outlives the ctrl-c of a statement
+			ctx = context.WithoutCancel(ctx)
+			repl, err := NewRepl(ctx, db, env.out, env.render)
+			if err != nil {
+				return err
+			}
+			defer repl.Close()
+			if execute != "" {
+				for _, stmt := range splitStatements(execute) {
+					if err := repl.Exec(ctx, stmt); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			if err := repl.LoadCompletions(ctx); err != nil {
+				fmt.Fprintln(os.Stderr, "load completions:", err)
+			}
+			return repl.Run(ctx)
+		},
+	}
+}
+
+func locksCommand() *command {
+	var kill bool
+	return &command{
+		name:  "locks",
+		short: "show the transactions waiting for a lock and who holds it",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&kill, "kill", false, "offer to close the session of every lock holder")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			loc, err := env.location()
+			if err != nil {
+				return err
+			}
+			waits, err := SelectLockWaits(db, loc, NonUserRawComment)
+			if err != nil {
+				return err
+			}
+			if err := env.print(waits); err != nil {
+				return err
+			}
+			if !kill {
+				return nil
+			}
+			in := bufio.NewReader(os.Stdin)
+			killed := make(map[string]bool)
+			for _, w := range waits {
+				if w.HolderSessionId == "" || killed[w.HolderSessionId] {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "kill session %s of %s@%s holding %s for %s, running %q? [y/N] ",
+					w.HolderSessionId, w.HolderUser, w.HolderAccount, w.Table, humanDuration(w.HolderAge),
+					truncateStatement(w.HolderStatement, env.render.statementWidth()))
+				answer, _ := in.ReadString('\n')
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+					continue
+				}
+				if err := KillLockHolder(db, w, NonUserRawComment); err != nil {
+					return err
+				}
+				killed[w.HolderSessionId] = true
+				fmt.Fprintf(env.out, "killed session %s\n", w.HolderSessionId)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// historyProj is the projection of history list and get, the duration of a
+	// running statement is the time elapsed so far.
+	historyProj   = "`statement`, system.statement_info.statement_id, IF(`status`='Running', TIMESTAMPDIFF(MICROSECOND,`request_at`,now())*1000, `duration`) AS `duration`, `status`, `request_at`, system.statement_info.response_at, `user`, system.statement_info.account, `database`, `transaction_id`, `session_id`, `rows_read`, `bytes_scan`, `error`, `err_code`, `result_count`"
+	historyCUProj = ", IF(status = 'Running', NULL, " + cuExpr + ") AS `cu`"
+	defaultRange  = "last 1h"
+)
+
+func historyCommand() *command {
+	return &command{
+		name:  "history",
+		short: "query system.statement_info",
+		subs: []*command{
+			historyListCommand(),
+			historyGetCommand(),
+			historyExportCommand(),
+			historyTailCommand(),
+			historyServeCommand(),
+			historyGRPCCommand(),
+		},
+	}
+}
+
+func historyListCommand() *command {
+	var (
+		account, user, status, timeRange string
+		limit, offset                    uint
+		cu                               bool
+		minCU                            uint
+	)
+	return &command{
+		name:  "list",
+		short: "list the statements of an account, the latest first",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&account, "account", "", "account of the statements")
+			fs.StringVar(&user, "user", "", "only the statements of this user")
+			fs.StringVar(&status, "status", "", "only the statements with this status, like Running or Failed")
+			fs.StringVar(&timeRange, "range", defaultRange, "request_at range, like \"last 15m\" or start/end")
+			fs.UintVar(&limit, "limit", 20, "max statements to list")
+			fs.UintVar(&offset, "offset", 0, "statements to skip")
+			fs.BoolVar(&cu, "cu", false, "also calculate the CU of every statement")
+			fs.UintVar(&minCU, "min-cu", 0, "only the statements above this CU, implies -cu")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			if account == "" {
+				return errors.New("-account is required")
+			}
+			start, end, err := env.parseRange(timeRange)
+			if err != nil {
+				return err
+			}
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+
+			cond, args := "account = ? and request_at between ? and ?", []any{account, start, end}
+			if user != "" {
+				cond += " and `user` = ?"
+				args = append(args, user)
+			}
+			if status != "" {
+				cond += " and status = ?"
+				args = append(args, status)
+			}
+			proj := historyProj
+			if minCU > 0 {
+				cu = true
+			}
+			if cu {
+				proj += historyCUProj
+			}
+			joinCond, joinArgs := "account = ?", []any{account}
+			si := StatementInfo{Account: account}
+			records, _, err := si.SelectStatements(db, proj, cond, args, "request_at desc",
+				limit, offset, NonUserRawComment, cu, &minCU, joinCond, joinArgs, false)
+			if err != nil {
+				return err
+			}
+			return env.print(records)
+		},
+	}
+}
+
+func historyGetCommand() *command {
+	var (
+		account, timeRange string
+		cu                 bool
+	)
+	return &command{
+		name:  "get",
+		args:  "<statement_id>",
+		short: "show one statement",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&account, "account", "", "account of the statement")
+			fs.StringVar(&timeRange, "range", "", "request_at range to look in, empty means any time")
+			fs.BoolVar(&cu, "cu", false, "also calculate the CU of the statement")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			if fs.NArg() != 1 {
+				fs.Usage()
+				return errUsage
+			}
+			si := StatementInfo{StatementId: fs.Arg(0), Account: account}
+			var start, end *time.Time
+			if timeRange != "" {
+				s, e, err := env.parseRange(timeRange)
+				if err != nil {
+					return err
+				}
+				start, end = &s, &e
+			}
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			proj := historyProj
+			if cu {
+				proj += historyCUProj
+			}
+			record, err := si.SelectByStatementId(db, &proj, start, end,
+				NonUserRawComment, cu, nil, false)
+			if err != nil {
+				return err
+			}
+			return env.print(record)
+		},
+	}
+}
+
+func historyExportCommand() *command {
+	var (
+		account, timeRange, format, compression, output string
+		chunkSize                                       int
+	)
+	return &command{
+		name:  "export",
+		short: "export the statements in a time range as csv, jsonl or parquet",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&account, "account", "", "account of the statements, empty means all")
+			fs.StringVar(&timeRange, "range", defaultRange, "request_at range, like \"last 15m\" or start/end")
+			fs.StringVar(&format, "format", string(ExportJSONL), "csv, jsonl or parquet")
+			fs.StringVar(&compression, "compress", "", "gzip or zstd, parquet compresses its pages instead")
+			fs.StringVar(&output, "o", "-", "output file, - for stdout")
+			fs.IntVar(&chunkSize, "chunk", defaultExportChunkSize, "rows fetched per query")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			start, end, err := env.parseRange(timeRange)
+			if err != nil {
+				return err
+			}
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			var w io.Writer = env.out
+			if output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			rows, err := ExportStatements(ctx, db, w, ExportOptions{
+				Account:     account,
+				Start:       start,
+				End:         end,
+				Format:      ExportFormat(format),
+				Compression: ExportCompression(compression),
+				ChunkSize:   chunkSize,
+				SQLComment:  NonUserRawComment,
+				Progress: func(p ExportProgress) {
+					fmt.Fprintf(os.Stderr, "exported %d rows, last request_at %s\n", p.Rows, p.LastRequestAt)
+				},
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "done, %d rows\n", rows)
+			return nil
+		},
+	}
+}
+
+func historyServeCommand() *command {
+	var addr string
+	cfg := DefaultQueryHistoryConfig()
+	return &command{
+		name:  "serve",
+		short: "serve the query-history HTTP API",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&addr, "addr", ":8080", "listen address")
+			queryHistoryFlags(fs, &cfg)
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.out, "serving on %s\n", addr)
+			return serveHTTP(ctx, addr, NewQueryHistoryAPI(db, cfg).Handler())
+		},
+	}
+}
+
+func historyGRPCCommand() *command {
+	var addr string
+	cfg := DefaultQueryHistoryConfig()
+	return &command{
+		name:  "grpc",
+		short: "serve the query-history and metrics gRPC API",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&addr, "addr", ":9000", "listen address")
+			queryHistoryFlags(fs, &cfg)
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.out, "serving on %s\n", addr)
+			return ServeGRPC(ctx, addr, NewGRPCServer(db, cfg))
+		},
+	}
+}
+
+// queryHistoryFlags binds the fields of cfg to the flags of the serve commands.
+func queryHistoryFlags(fs *flag.FlagSet, cfg *QueryHistoryConfig) {
+	fs.BoolVar(&cfg.EnableStatementCU, "statement-cu", cfg.EnableStatementCU, "read the CU from mo_catalog.statement_cu")
+	fs.BoolVar(&cfg.EnableStatsCU, "stats-cu", cfg.EnableStatsCU, "calculate the CU from the stats")
+	fs.DurationVar(&cfg.ResponseAtExtension, "response-at-extension", cfg.ResponseAtExtension, "how long after the window statement_cu is read")
+	fs.DurationVar(&cfg.MaxTimeWindow, "max-window", cfg.MaxTimeWindow, "max time window of a request")
+	fs.UintVar(&cfg.DefaultPageSize, "page-size", cfg.DefaultPageSize, "default page size")
+	fs.UintVar(&cfg.MaxPageSize, "max-page-size", cfg.MaxPageSize, "max page size")
+	fs.UintVar(&cfg.MaxMinCU, "max-min-cu", cfg.MaxMinCU, "max min_cu filter, 0 means no bound")
+	fs.Func("tokens", "yaml file of the bearer tokens of the callers, token: account; without it the account headers are trusted", func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &cfg.AccountTokens); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+func historyTailCommand() *command {
+	var (
+		opts  TailOptions
+		since time.Duration
+	)
+	return &command{
+		name:  "tail",
+		short: "follow the new finished statements, like tail -f",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&opts.Account, "account", "", "account of the statements, empty means all")
+			fs.StringVar(&opts.User, "user", "", "only the statements of this user")
+			fs.DurationVar(&opts.MinDuration, "min-duration", 0, "only the statements taking at least this long")
+			fs.BoolVar(&opts.ErrorsOnly, "errors", false, "only the failed statements")
+			fs.DurationVar(&since, "since", 0, "also print the statements requested this long ago")
+			fs.DurationVar(&opts.Interval, "interval", defaultTailInterval, "poll interval")
+			fs.DurationVar(&opts.Overlap, "overlap", defaultTailOverlap, "how late a statement may arrive")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			switch env.render.Format {
+			case OutputTable, OutputJSON, OutputNDJSON:
+			default:
+				return fmt.Errorf("tail prints table, json or ndjson, not %s", env.render.Format)
+			}
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			loc, err := env.location()
+			if err != nil {
+				return err
+			}
+			opts.Since = time.Now().Add(-since)
+			opts.SQLComment = NonUserRawComment
+			err = NewTailer(db, opts).Run(ctx, func(s StatementInfo) error {
+				if env.render.Format != OutputTable {
+					return renderNDJSON(env.out, s)
+				}
+				_, err := fmt.Fprintf(env.out, "%s  %-7s  %8s  %s@%s  %s  %s\n",
+					s.RequestAt.In(loc).Format("2006-01-02 15:04:05.000"), s.Status, humanDuration(time.Duration(s.Duration)),
+					s.User, s.Account, s.StatementId, truncateStatement(s.Statement, env.render.statementWidth()))
+				return err
+			})
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func metricsCommand() *command {
+	return &command{
+		name:  "metrics",
+		short: "query system_metrics",
+		subs: []*command{
+			metricsQueryCommand(),
+			metricsCatalogCommand(),
+			metricsServeCommand(),
+		},
+	}
+}
+
+// parseLabelMatcher parses a -match flag like node=~"cn.*" without the quotes, node=~cn.*.
+func parseLabelMatcher(s string) (LabelMatcher, error) {
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if i := strings.Index(s, op); i > 0 {
+			return LabelMatcher{Name: s[:i], Op: op, Value: s[i+len(op):]}, nil
+		}
+	}
+	return LabelMatcher{}, fmt.Errorf("invalid label matcher: %s", s)
+}
+
+func metricsQueryCommand() *command {
+	var (
+		table, timeRange, seriesBy, seriesAgg, groupBy, agg, fill, promql string
+		step                                                              time.Duration
+		matchers                                                          stringsFlag
+		perSecond, deltaCounters, instant                                 bool
+	)
+	return &command{
+		name:  "query",
+		short: "bucket one metric table over a time range, or evaluate -promql",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&table, "table", "", "metric table, like sql_statement_total")
+			fs.StringVar(&timeRange, "range", defaultRange, "collecttime range, like \"last 15m\" or start/end")
+			fs.DurationVar(&step, "step", defaultMetricStep, "bucket width")
+			fs.Var(&matchers, "match", "label matcher like node=cn1 or type=~select|insert, repeatable")
+			fs.StringVar(&seriesBy, "series-by", "", "comma separated labels of a raw series")
+			fs.StringVar(&seriesAgg, "series-agg", defaultMetricAggFn, "aggregation within a series and bucket")
+			fs.StringVar(&groupBy, "by", "", "comma separated labels of the result series")
+			fs.StringVar(&agg, "agg", defaultMetricAggFn, "aggregation across the series")
+			fs.BoolVar(&perSecond, "per-second", false, "divide the values by the step")
+			fs.StringVar(&fill, "fill", "", "zero, null, previous or linear for the empty buckets")
+			fs.StringVar(&promql, "promql", "", "PromQL expression, replaces the table flags")
+			fs.BoolVar(&instant, "instant", false, "evaluate -promql at the end of the range only")
+			fs.BoolVar(&deltaCounters, "delta-counters", true, "counter samples are increases, false reads them as cumulative")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			start, end, err := env.parseRange(timeRange)
+			if err != nil {
+				return err
+			}
+			if promql == "" && table == "" {
+				return fmt.Errorf("one of -table and -promql is required")
+			}
+			q := MetricQuery{
+				Table:     table,
+				Start:     start,
+				End:       end,
+				Step:      step,
+				SeriesBy:  splitList(seriesBy),
+				SeriesAgg: seriesAgg,
+				GroupBy:   splitList(groupBy),
+				Agg:       agg,
+				PerSecond: perSecond,
+				Fill:      FillPolicy(fill),
+			}
+			for _, m := range matchers {
+				matcher, err := parseLabelMatcher(m)
+				if err != nil {
+					return err
+				}
+				q.Matchers = append(q.Matchers, matcher)
+			}
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+
+			if promql != "" {
+				pq := PromQuery{Query: promql, Start: start, End: end, Step: step, CumulativeCounters: !deltaCounters}
+				if instant {
+					samples, err := QueryPromQL(db, pq, NonUserRawComment)
+					if err != nil {
+						return err
+					}
+					return env.print(samples)
+				}
+				series, err := QueryPromQLRange(db, pq, NonUserRawComment)
+				if err != nil {
+					return err
+				}
+				return env.print(series)
+			}
+			series, err := QueryMetrics(db, q, NonUserRawComment)
+			if err != nil {
+				return err
+			}
+			return env.print(series)
+		},
+	}
+}
+
+// metricsCatalogCommand lists the metrics of system_metrics.
+func metricsCatalogCommand() *command {
+	var timeRange bool
+	return &command{
+		name:  "catalog",
+		short: "list the metric tables with their kind and labels",
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&timeRange, "time-range", false, "read the earliest and latest collecttime, scans every table")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			catalog, err := SelectMetricCatalog(db, timeRange, NonUserRawComment)
+			if err != nil {
+				return err
+			}
+			return env.print(catalog)
+		},
+	}
+}
+
+func metricsServeCommand() *command {
+	var (
+		addr          string
+		deltaCounters bool
+	)
+	return &command{
+		name:  "serve",
+		short: "serve the Prometheus HTTP query API over system_metrics",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&addr, "addr", ":9090", "listen address")
+			fs.BoolVar(&deltaCounters, "delta-counters", true, "counter samples are increases, false reads them as cumulative")
+		},
+		run: func(ctx context.Context, env *cliEnv, fs *flag.FlagSet) error {
+			db, err := env.open(ctx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(env.out, "serving on %s\n", addr)
+			return serveHTTP(ctx, addr, NewPromAPI(db, !deltaCounters).Handler())
+		},
+	}
+}
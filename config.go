@@ -37,6 +37,9 @@ type Config struct {
 	PPV2Enabled bool `json:"ppv2Enabled" yaml:"ppv2Enabled"`
 	// ClientIP is the client source IP
 	ClientIP string `json:"clientIP" yaml:"clientIP"`
+	// TimeZone, like Asia/Shanghai, is used to read and write DATETIME
+	// values and as the session time_zone
+	TimeZone string `json:"timeZone" yaml:"timeZone"`
 }
 
 func connDBForUser(ctx context.Context, cfg Config, log gormlogger.Interface) (*gorm.DB, error) {
@@ -87,6 +90,14 @@ func OpenDB(cfg Config, logger gormlogger.Interface, opts ...MysqlConfigOption)
 	mysqlCfg.AllowNativePasswords = true
 	mysqlCfg.ParseTime = true
 	mysqlCfg.Timeout = time.Second * 30
+	if cfg.TimeZone != "" {
+		loc, err := cfg.Location()
+		if err != nil {
+			return nil, err
+		}
+		mysqlCfg.Loc = loc
+		mysqlCfg.Params = map[string]string{"time_zone": "'" + sessionTimeZone(loc, time.Now()) + "'"}
+	}
 
 	for _, opt := range opts {
 		opt(mysqlCfg)
@@ -114,37 +125,66 @@ func OpenDB(cfg Config, logger gormlogger.Interface, opts ...MysqlConfigOption)
 	})
 }
 
+// Location returns the time zone of cfg, UTC like the driver when TimeZone is empty.
+func (c *Config) Location() (*time.Location, error) {
+	if c.TimeZone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(c.TimeZone)
+}
+
+// sessionTimeZone is the value for the session time_zone of loc, IANA zones keep
+// their name so daylight saving follows, others become their current offset.
+func sessionTimeZone(loc *time.Location, now time.Time) string {
+	if name := loc.String(); name != "Local" && name != "UTC" {
+		if _, err := time.LoadLocation(name); err == nil {
+			return name
+		}
+	}
+	_, offset := now.In(loc).Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
 func (c *Config) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if c.ClientIP == "" {
+		return nil, fmt.Errorf("invalid client IP")
+	}
+	ip := net.ParseIP(c.ClientIP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client IP %q", c.ClientIP)
+	}
 	nd := net.Dialer{Timeout: 10 * time.Second}
 	conn, err := nd.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	if conn == nil {
-		return nil, err
-	}
-	if c.ClientIP == "" {
-		return nil, fmt.Errorf("invalid client IP")
-	}
 	// Create a proxyprotocol header
 	header := &proxyproto.Header{
 		Version:           2,
 		Command:           proxyproto.PROXY,
 		TransportProtocol: proxyproto.TCPv4,
 		SourceAddr: &net.TCPAddr{
-			IP: net.ParseIP(c.ClientIP),
+			IP: ip,
 		},
 		// dummy dest addr
 		DestinationAddr: &net.TCPAddr{
 			IP: net.ParseIP("127.0.0.1"),
 		},
 	}
+	if ip.To4() == nil {
+		header.TransportProtocol = proxyproto.TCPv6
+		header.DestinationAddr = &net.TCPAddr{IP: net.IPv6loopback}
+	}
 	// After the connection was created write the proxy headers first
-	_, err = header.WriteTo(conn)
-	if err != nil {
+	if _, err := header.WriteTo(conn); err != nil {
+		conn.Close()
 		return nil, err
 	}
-	return conn, err
+	return conn, nil
 }
 
 type Logger struct {
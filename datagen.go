@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	statementCUDBTable  = "mo_catalog.statement_cu"
+	defaultGenBatchSize = 500
+)
+
+// genTemplates are the shapes of the generated statements, every ? becomes a literal in
+// the statement and stays in the fingerprint. %s is the table.
+var genTemplates = []struct {
+	sql           string
+	statementType string
+	queryType     string
+	// median duration in ms
+	median float64
+}{
+	{"select * from %s where id = ?", "Select", "DQL", 2},
+	{"select count(*) from %s where created_at > ?", "Select", "DQL", 80},
+	{"select id, name, status from %s where status = ? order by id desc limit ?", "Select", "DQL", 15},
+	{"select a.id, b.name from %s a join users b on a.user_id = b.id where a.id in (?, ?, ?)", "Select", "DQL", 40},
+	{"select status, sum(amount) from %s where created_at between ? and ? group by status", "Select", "DQL", 900},
+	{"insert into %s values (?, ?, ?)", "Insert", "DML", 5},
+	{"update %s set name = ? where id = ?", "Update", "DML", 8},
+	{"delete from %s where expired_at < ?", "Delete", "DML", 120},
+	{"load data infile ? into table %s", "Load", "DML", 20000},
+	{"create table if not exists %s_bak like %[1]s", "CreateTable", "DDL", 300},
+	{"show columns from %s", "ShowColumns", "Other", 3},
+}
+
+var (
+	genDatabases = []string{"shop", "crm", "logs", "test"}
+	genTables    = []string{"orders", "users", "sessions", "items", "events", "payments"}
+	genUsers     = []string{"root", "admin", "dump", "app", "etl", "report"}
+	genErrors    = []struct{ code, message string }{
+		{"20101", "internal error: context deadline exceeded"},
+		{"20301", "invalid input: duplicate entry for key 'id'"},
+		{"1064", "SQL parser error: You have an error in your SQL syntax"},
+		{"20105", "invalid argument operator cast, bad value"},
+		{"20404", "txn need retry in rc mode"},
+		{"1045", "Access denied for user"},
+	}
+	genSourceTypes = []string{"external_sql", "external_sql", "external_sql", "cloud_user_sql", "internal_sql"}
+)
+
+type StatementGenOptions struct {
+	// Seed makes the output reproducible, the same options give the same rows.
+	Seed int64
+	// Rows is the number of statements, spread over [Start, End) by request_at.
+	Rows  int
+	Start time.Time
+	End   time.Time
+
+	Accounts        int
+	UsersPerAccount int
+	Fingerprints    int
+	Nodes           int
+	// Skew is the s of the Zipf distribution fingerprints, accounts and users are picked
+	// with, larger is more skewed, it must be > 1.
+	Skew float64
+	// ErrorRate and RunningRate are the shares of Failed and Running statements, the
+	// rest is Success.
+	ErrorRate   float64
+	RunningRate float64
+	// StatsVersions are the versions of the stats arrays, picked uniformly.
+	StatsVersions []int
+}
+
+func DefaultStatementGenOptions() StatementGenOptions {
+	end := time.Now().Truncate(time.Second)
+	return StatementGenOptions{
+		Seed:            1,
+		Rows:            10000,
+		Start:           end.Add(-time.Hour),
+		End:             end,
+		Accounts:        5,
+		UsersPerAccount: 3,
+		Fingerprints:    40,
+		Nodes:           3,
+		Skew:            1.3,
+		ErrorRate:       0.03,
+		RunningRate:     0.01,
+		StatsVersions:   []int{1, 2, 3, 4},
+	}
+}
+
+func (o *StatementGenOptions) validate() error {
+	switch {
+	case o.Rows < 0:
+		return fmt.Errorf("invalid rows %d", o.Rows)
+	case o.Start.IsZero() || o.End.IsZero() || !o.Start.Before(o.End):
+		return errors.New("invalid time range")
+	case o.Accounts < 1 || o.UsersPerAccount < 1 || o.Fingerprints < 1 || o.Nodes < 1:
+		return errors.New("accounts, users, fingerprints and nodes must be at least 1")
+	case o.Skew <= 1:
+		return fmt.Errorf("skew must be > 1, got %v", o.Skew)
+	case o.ErrorRate < 0 || o.RunningRate < 0 || o.ErrorRate+o.RunningRate > 1:
+		return errors.New("error and running rates must be in [0, 1] and add up to at most 1")
+	case len(o.StatsVersions) == 0:
+		return errors.New("no stats versions")
+	}
+	for _, v := range o.StatsVersions {
+		if v < 1 || v > 4 {
+			return fmt.Errorf("unknown stats version %d, 1 to 4 are known", v)
+		}
+	}
+	return nil
+}
+
+// GeneratedStatement is one row of statement_info with its stats as MO stores them and
+// the row of statement_cu, which only finished statements have.
+type GeneratedStatement struct {
+	StatementInfo
+	StatsArray  []float64
+	StatementCU *StatementCURow
+}
+
+// StatementCURow is a row of mo_catalog.statement_cu.
+type StatementCURow struct {
+	StatementId string    `json:"statement_id"`
+	Account     string    `json:"account"`
+	AccountId   uint32    `json:"account_id"`
+	ResponseAt  time.Time `json:"response_at"`
+	CU          float64   `json:"cu"`
+	NodeType    string    `json:"node_type"`
+}
+
+type genFingerprint struct {
+	fingerprint   string
+	database      string
+	table         string
+	statementType string
+	queryType     string
+	median        float64
+	// errors makes some fingerprints fail more than others, errorRate is the share of
+	// its statements that fail
+	errors    float64
+	errorRate float64
+}
+
+type genAccount struct {
+	name     string
+	id       uint32
+	users    []string
+	sessions [][]string
+}
+
+// StatementGenerator produces realistic statement_info rows: a few fingerprints, accounts
+// and users account for most statements, durations are log-normal around a median per
+// fingerprint, and every finished statement has its CU in statement_cu.
+type StatementGenerator struct {
+	opts StatementGenOptions
+	r    *rand.Rand
+
+	fingerprints []genFingerprint
+	accounts     []genAccount
+	nodes        []string
+	pickFP       *rand.Zipf
+	pickAccount  *rand.Zipf
+	pickUser     []*rand.Zipf
+
+	n int
+}
+
+func NewStatementGenerator(opts StatementGenOptions) (*StatementGenerator, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	r := rand.New(rand.NewSource(opts.Seed))
+	g := &StatementGenerator{opts: opts, r: r}
+
+	for i := 0; i < opts.Fingerprints; i++ {
+		// every template on every table of every database before repeating
+		t := genTemplates[i%len(genTemplates)]
+		k := i / len(genTemplates)
+		fp := genFingerprint{
+			database:      genDatabases[k/len(genTables)%len(genDatabases)],
+			table:         genTables[k%len(genTables)],
+			statementType: t.statementType,
+			queryType:     t.queryType,
+			median:        t.median * math.Exp(r.NormFloat64()*0.5),
+			errors:        math.Exp(r.NormFloat64()),
+		}
+		fp.fingerprint = fmt.Sprintf(t.sql, fp.database+"."+fp.table)
+		g.fingerprints = append(g.fingerprints, fp)
+	}
+	g.scaleErrorRates()
+	for i := 0; i < opts.Accounts; i++ {
+		a := genAccount{name: sysAccount, id: uint32(i)}
+		if i > 0 {
+			a.name = fmt.Sprintf("tenant_%03d", i)
+		}
+		for j := 0; j < opts.UsersPerAccount; j++ {
+			user := genUsers[j%len(genUsers)]
+			if j >= len(genUsers) {
+				user += strconv.Itoa(j / len(genUsers))
+			}
+			a.users = append(a.users, user)
+			a.sessions = append(a.sessions, []string{g.uuid(opts.Start), g.uuid(opts.Start)})
+		}
+		g.accounts = append(g.accounts, a)
+		g.pickUser = append(g.pickUser, g.zipf(opts.UsersPerAccount))
+	}
+	for i := 0; i < opts.Nodes; i++ {
+		g.nodes = append(g.nodes, g.uuid(opts.Start))
+	}
+	g.pickFP = g.zipf(opts.Fingerprints)
+	g.pickAccount = g.zipf(opts.Accounts)
+	return g, nil
+}
+
+// scaleErrorRates spreads ErrorRate over the fingerprints by their errors factor. The
+// rates are scaled until their mean, weighted like pickFP picks the fingerprints,
+// (1+k)^-Skew for the k-th, is ErrorRate, a rate being at most 1-RunningRate.
+func (g *StatementGenerator) scaleErrorRates() {
+	weights := make([]float64, len(g.fingerprints))
+	var total float64
+	for k := range weights {
+		weights[k] = math.Pow(float64(1+k), -g.opts.Skew)
+		total += weights[k]
+	}
+	rates := func(scale float64) float64 {
+		var mean float64
+		for k := range g.fingerprints {
+			fp := &g.fingerprints[k]
+			fp.errorRate = math.Min(scale*fp.errors, 1-g.opts.RunningRate)
+			mean += weights[k] * fp.errorRate
+		}
+		return mean / total
+	}
+	scale := g.opts.ErrorRate
+	for i := 0; i < 50 && scale > 0; i++ {
+		mean := rates(scale)
+		if math.Abs(mean-g.opts.ErrorRate) < 1e-9 {
+			return
+		}
+		scale *= g.opts.ErrorRate / mean
+	}
+	rates(scale)
+}
+
+// zipf picks in [0, n), 0 the most often.
+func (g *StatementGenerator) zipf(n int) *rand.Zipf {
+	return rand.NewZipf(g.r, g.opts.Skew, 1, uint64(n-1))
+}
+
+// uuid is a version 7 uuid like the statement ids of MO, time ordered by t.
+func (g *StatementGenerator) uuid(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	g.r.Read(b[6:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Next returns the next statement, ordered by request_at, and false after Rows statements.
+func (g *StatementGenerator) Next() (GeneratedStatement, bool) {
+	if g.n >= g.opts.Rows {
+		return GeneratedStatement{}, false
+	}
+	r := g.r
+	step := float64(g.opts.End.Sub(g.opts.Start)) / float64(g.opts.Rows)
+	requestAt := g.opts.Start.Add(time.Duration(step * (float64(g.n) + r.Float64()))).Truncate(time.Microsecond)
+	g.n++
+
+	fp := g.fingerprints[g.pickFP.Uint64()]
+	ai := g.pickAccount.Uint64()
+	a := g.accounts[ai]
+	ui := g.pickUser[ai].Uint64()
+	node := g.nodes[r.Intn(len(g.nodes))]
+
+	s := GeneratedStatement{StatementInfo: StatementInfo{
+		StatementId:          g.uuid(requestAt),
+		TransactionId:        g.uuid(requestAt),
+		SessionId:            a.sessions[ui][r.Intn(len(a.sessions[ui]))],
+		Account:              a.name,
+		User:                 a.users[ui],
+		Host:                 fmt.Sprintf("10.0.%d.%d", ai, 10+ui),
+		Database:             fp.database,
+		Statement:            g.fill(fp.fingerprint),
+		StatementTag:         fp.statementType,
+		StatementFingerprint: fp.fingerprint,
+		NodeUuid:             node,
+		NodeType:             "CN",
+		StatementType:        fp.statementType,
+		QueryType:            fp.queryType,
+		SqlSourceType:        genSourceTypes[r.Intn(len(genSourceTypes))],
+	}}
+	roleID := uint64(ui)
+	s.RoleId = &roleID
+	s.RequestAt = &requestAt
+
+	roll := r.Float64()
+	status := "Success"
+	switch {
+	case roll < g.opts.RunningRate:
+		status = runningStatus
+	case roll < g.opts.RunningRate+fp.errorRate:
+		status = failedStatus
+	}
+	s.Status = status
+
+	version := g.opts.StatsVersions[r.Intn(len(g.opts.StatsVersions))]
+	if status == runningStatus {
+		// no response yet, MO writes the request time and empty stats
+		s.ResponseAt = &requestAt
+		s.StatsArray = genStats(version, 0, 0, 0, 0, 0, 0)
+		zero := uint64(0)
+		s.RowsRead, s.BytesScan = &zero, &zero
+		return s, true
+	}
+
+	// log-normal around the median of the fingerprint, a failed statement stops early
+	duration := time.Duration(fp.median * math.Exp(r.NormFloat64()) * float64(time.Millisecond))
+	if status == failedStatus {
+		e := genErrors[r.Intn(len(genErrors))]
+		s.ErrCode, s.Error = e.code, e.message
+		duration = time.Duration(float64(duration) * r.Float64())
+	}
+	duration = max(duration, 50*time.Microsecond)
+	responseAt := requestAt.Add(duration).Truncate(time.Microsecond)
+	s.ResponseAt = &responseAt
+	s.Duration = uint64(duration)
+
+	var rowsRead, bytesScan uint64
+	if fp.queryType == "DQL" || fp.statementType == "Update" || fp.statementType == "Delete" {
+		rowsRead = uint64(math.Exp(r.NormFloat64()*2 + math.Log(1+fp.median)))
+		bytesScan = rowsRead * uint64(64+r.Intn(512))
+	}
+	if status == "Success" && fp.queryType == "DQL" {
+		s.ResultCount = int64(rowsRead) / int64(1+r.Intn(10))
+	}
+	s.RowsRead, s.BytesScan = &rowsRead, &bytesScan
+
+	cpu := math.Round(float64(duration) * (0.2 + 0.6*r.Float64()))
+	memory := math.Round(float64(bytesScan) * (1 + r.Float64()))
+	s3In := math.Ceil(float64(bytesScan) / (1 << 20))
+	s3Out := 0.0
+	if fp.queryType == "DML" || fp.queryType == "DDL" {
+		s3Out = float64(r.Intn(4))
+	}
+	network := float64(s.ResultCount) * float64(32+r.Intn(256))
+	cu := genCU(cpu, memory, s3In, s3Out, network)
+	s.StatsArray = genStats(version, cpu, memory, s3In, s3Out, network, cu)
+	s.CU = &cu
+	s.StatementCU = &StatementCURow{
+		StatementId: s.StatementId,
+		Account:     a.name,
+		AccountId:   a.id,
+		ResponseAt:  responseAt,
+		CU:          cu,
+		NodeType:    s.NodeType,
+	}
+	return s, true
+}
+
+// fill replaces every ? of the fingerprint with a literal.
+func (g *StatementGenerator) fill(fingerprint string) string {
+	parts := strings.Split(fingerprint, "?")
+	var b strings.Builder
+	for i, p := range parts {
+		b.WriteString(p)
+		if i == len(parts)-1 {
+			break
+		}
+		if g.r.Intn(3) == 0 {
+			fmt.Fprintf(&b, "'%s'", genUsers[g.r.Intn(len(genUsers))])
+		} else {
+			b.WriteString(strconv.Itoa(g.r.Intn(100000)))
+		}
+	}
+	return b.String()
+}
+
+// genStats lays the stats out like MO does for version: v1 has time consumed, memory and
+// the s3 IO counts, v2 adds the network IO, v3 the connection type and v4 the outbound
+// traffic and the CU.
+func genStats(version int, cpu, memory, s3In, s3Out, network, cu float64) []float64 {
+	stats := []float64{float64(version), cpu, memory, s3In, s3Out}
+	if version >= 2 {
+		stats = append(stats, network)
+	}
+	if version >= 3 {
+		stats = append(stats, float64(ConnTypeExternal))
+	}
+	if version >= 4 {
+		stats = append(stats, network, cu)
+	}
+	return stats
+}
+
+// genCU is shaped like the CU of MO, growing with cpu, memory and IO, but not its formula.
+// It is rounded like the decimal(32,4) of statement_cu.
+func genCU(cpu, memory, s3In, s3Out, network float64) float64 {
+	cu := cpu/1e9*0.5 + memory/(1<<30)*0.01 + s3In*1e-4 + s3Out*1e-3 + network/(1<<30)*0.1
+	return math.Round(cu*1e4) / 1e4
+}
+
+// statementRow is s as the columns of statement_info.
+func (s *GeneratedStatement) statementRow() (map[string]any, error) {
+	stats, err := json.Marshal(s.StatsArray)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"statement_id":          s.StatementId,
+		"transaction_id":        s.TransactionId,
+		"session_id":            s.SessionId,
+		"account":               s.Account,
+		"user":                  s.User,
+		"host":                  s.Host,
+		"database":              s.Database,
+		"statement":             s.Statement,
+		"statement_tag":         s.StatementTag,
+		"statement_fingerprint": s.StatementFingerprint,
+		"node_uuid":             s.NodeUuid,
+		"node_type":             s.NodeType,
+		"request_at":            *s.RequestAt,
+		"response_at":           *s.ResponseAt,
+		"duration":              s.Duration,
+		"status":                s.Status,
+		"err_code":              s.ErrCode,
+		"error":                 s.Error,
+		"exec_plan":             "{}",
+		"rows_read":             *s.RowsRead,
+		"bytes_scan":            *s.BytesScan,
+		"stats":                 string(stats),
+		"statement_type":        s.StatementType,
+		"query_type":            s.QueryType,
+		"role_id":               *s.RoleId,
+		"sql_source_type":       s.SqlSourceType,
+		"result_count":          s.ResultCount,
+	}, nil
+}
+
+// exportRecord is s as the rows of history export, so both read the same.
+func (s *GeneratedStatement) exportRecord() (*ExportRecord, error) {
+	stats, err := json.Marshal(s.StatsArray)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportRecord{
+		StatementId:          s.StatementId,
+		TransactionId:        s.TransactionId,
+		SessionId:            s.SessionId,
+		Account:              s.Account,
+		User:                 s.User,
+		Host:                 s.Host,
+		Database:             s.Database,
+		Statement:            s.Statement,
+		StatementTag:         s.StatementTag,
+		StatementFingerprint: s.StatementFingerprint,
+		NodeUuid:             s.NodeUuid,
+		NodeType:             s.NodeType,
+		RequestAt:            *s.RequestAt,
+		ResponseAt:           *s.ResponseAt,
+		Duration:             s.Duration,
+		Status:               s.Status,
+		ErrCode:              s.ErrCode,
+		Error:                s.Error,
+		RowsRead:             *s.RowsRead,
+		BytesScan:            *s.BytesScan,
+		Stats:                string(stats),
+		StatementType:        s.StatementType,
+		QueryType:            s.QueryType,
+		SqlSourceType:        s.SqlSourceType,
+		ResultCount:          s.ResultCount,
+	}, nil
+}
+
+func (c *StatementCURow) row() map[string]any {
+	return map[string]any{
+		"statement_id": c.StatementId,
+		"account":      c.Account,
+		"account_id":   c.AccountId,
+		"response_at":  c.ResponseAt,
+		"cu":           c.CU,
+		"node_type":    c.NodeType,
+	}
+}
+
+// GenProgress is reported after each batch.
+type GenProgress struct {
+	Statements int
+	CU         int
+}
+
+// GenerateToDB inserts the statements of g into system.statement_info and their CU into
+// mo_catalog.statement_cu, batchSize rows per insert.
+func GenerateToDB(ctx context.Context, db *gorm.DB, g *StatementGenerator, batchSize int, progress func(GenProgress)) (GenProgress, error) {
+	if batchSize <= 0 {
+		batchSize = defaultGenBatchSize
+	}
+	var (
+		done       GenProgress
+		statements = make([]map[string]any, 0, batchSize)
+		cus        = make([]map[string]any, 0, batchSize)
+	)
+	flush := func() error {
+		if len(statements) > 0 {
+			if err := db.WithContext(ctx).Table(statementInfoDBTable).Create(statements).Error; err != nil {
+				return fmt.Errorf("insert into %s: %w", statementInfoDBTable, err)
+			}
+		}
+		if len(cus) > 0 {
+			if err := db.WithContext(ctx).Table(statementCUDBTable).Create(cus).Error; err != nil {
+				return fmt.Errorf("insert into %s: %w", statementCUDBTable, err)
+			}
+		}
+		done.Statements += len(statements)
+		done.CU += len(cus)
+		statements, cus = statements[:0], cus[:0]
+		if progress != nil {
+			progress(done)
+		}
+		return nil
+	}
+
+	for {
+		s, ok := g.Next()
+		if !ok {
+			break
+		}
+		row, err := s.statementRow()
+		if err != nil {
+			return done, err
+		}
+		statements = append(statements, row)
+		if s.StatementCU != nil {
+			cus = append(cus, s.StatementCU.row())
+		}
+		if len(statements) == batchSize {
+			if err := flush(); err != nil {
+				return done, err
+			}
+		}
+	}
+	if len(statements) == 0 {
+		return done, nil
+	}
+	return done, flush()
+}
+
+// GenerateToFiles writes the statements of g to statements in the format of history
+// export, csv or jsonl, and their CU to cu in the same format.
+func GenerateToFiles(statements, cu io.Writer, format ExportFormat, g *StatementGenerator) (GenProgress, error) {
+	var done GenProgress
+	if format != ExportCSV && format != ExportJSONL {
+		return done, fmt.Errorf("unknown format %s, csv or jsonl", format)
+	}
+	sw, err := newExportWriter(statements, format, CompressionNone)
+	if err != nil {
+		return done, err
+	}
+	cw := newCUWriter(cu, format)
+
+	for {
+		s, ok := g.Next()
+		if !ok {
+			break
+		}
+		record, err := s.exportRecord()
+		if err == nil {
+			err = sw.Write(record)
+		}
+		if err == nil && s.StatementCU != nil {
+			err = cw.write(s.StatementCU)
+			done.CU++
+		}
+		if err != nil {
+			sw.Close()
+			return done, err
+		}
+		done.Statements++
+	}
+	if err := cw.flush(); err != nil {
+		sw.Close()
+		return done, err
+	}
+	return done, sw.Close()
+}
+
+// cuWriter writes StatementCURow as csv or jsonl.
+type cuWriter struct {
+	buf *bufio.Writer
+	csv *csv.Writer
+	enc *json.Encoder
+}
+
+var cuCSVHeader = []string{"statement_id", "account", "account_id", "response_at", "cu", "node_type"}
+
+func newCUWriter(w io.Writer, format ExportFormat) *cuWriter {
+	c := &cuWriter{buf: bufio.NewWriter(w)}
+	if format == ExportCSV {
+		c.csv = csv.NewWriter(c.buf)
+		c.csv.Write(cuCSVHeader)
+	} else {
+		c.enc = json.NewEncoder(c.buf)
+	}
+	return c
+}
+
+func (c *cuWriter) write(r *StatementCURow) error {
+	if c.csv == nil {
+		return c.enc.Encode(r)
+	}
+	return c.csv.Write([]string{
+		r.StatementId, r.Account, strconv.FormatUint(uint64(r.AccountId), 10),
+		r.ResponseAt.Format(time.RFC3339Nano), strconv.FormatFloat(r.CU, 'f', 4, 64), r.NodeType,
+	})
+}
+
+func (c *cuWriter) flush() error {
+	if c.csv != nil {
+		c.csv.Flush()
+		if err := c.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return c.buf.Flush()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func genOptions(seed int64, rows int) StatementGenOptions {
+	opts := DefaultStatementGenOptions()
+	opts.Seed, opts.Rows = seed, rows
+	opts.Start, opts.End = fixtureStart, fixtureStart.Add(time.Hour)
+	return opts
+}
+
+func generateAll(t *testing.T, opts StatementGenOptions) []GeneratedStatement {
+	t.Helper()
+	g, err := NewStatementGenerator(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []GeneratedStatement
+	for s, ok := g.Next(); ok; s, ok = g.Next() {
+		all = append(all, s)
+	}
+	return all
+}
+
+func TestStatementGeneratorSeed(t *testing.T) {
+	a, b := generateAll(t, genOptions(7, 500)), generateAll(t, genOptions(7, 500))
+	if len(a) != 500 || !reflect.DeepEqual(a, b) {
+		t.Fatalf("got %d rows, want the same 500 rows for the same seed", len(a))
+	}
+	if c := generateAll(t, genOptions(8, 500)); reflect.DeepEqual(a, c) {
+		t.Error("got the same rows for another seed")
+	}
+	for i, s := range a {
+		if s.RequestAt.Before(fixtureStart) || !s.RequestAt.Before(fixtureStart.Add(time.Hour)) ||
+			(i > 0 && s.RequestAt.Before(*a[i-1].RequestAt)) {
+			t.Fatalf("row %d: request_at %s out of order or range", i, s.RequestAt)
+		}
+	}
+}
+
+func TestStatementGeneratorCU(t *testing.T) {
+	opts := genOptions(1, 2000)
+	opts.ErrorRate, opts.RunningRate = 0.2, 0.2
+	for _, s := range generateAll(t, opts) {
+		switch {
+		case s.Status == runningStatus && s.StatementCU != nil:
+			t.Fatalf("%s: running statement with a statement_cu row", s.StatementId)
+		case s.Status == runningStatus:
+		case s.StatementCU == nil:
+			t.Fatalf("%s: %s statement without a statement_cu row", s.StatementId, s.Status)
+		case s.StatementCU.StatementId != s.StatementId || s.StatementCU.CU != *s.CU ||
+			!s.StatementCU.ResponseAt.Equal(*s.ResponseAt) || s.StatementCU.Account != s.Account:
+			t.Fatalf("%s: statement_cu %+v does not match the statement", s.StatementId, *s.StatementCU)
+		}
+	}
+}
+
+func TestStatementGeneratorRates(t *testing.T) {
+	for _, tc := range []struct {
+		errorRate, runningRate float64
+	}{
+		{0.03, 0.01},
+		{0.1, 0.05},
+		{0, 0.3},
+		{0.5, 0},
+	} {
+		for _, seed := range []int64{1, 2, 3} {
+			opts := genOptions(seed, 20000)
+			opts.ErrorRate, opts.RunningRate = tc.errorRate, tc.runningRate
+			var failed, running float64
+			all := generateAll(t, opts)
+			for _, s := range all {
+				switch s.Status {
+				case failedStatus:
+					failed++
+				case runningStatus:
+					running++
+				}
+			}
+			failed, running = failed/float64(len(all)), running/float64(len(all))
+			// a tenth of the rate, and at least 0.005, around the expected value
+			if math.Abs(failed-tc.errorRate) > max(tc.errorRate/10, 0.005) ||
+				math.Abs(running-tc.runningRate) > max(tc.runningRate/10, 0.005) {
+				t.Errorf("seed %d: got error rate %.4f running rate %.4f, want %v %v",
+					seed, failed, running, tc.errorRate, tc.runningRate)
+			}
+		}
+	}
+}
+
+func TestStatementGenOptionsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(*StatementGenOptions)
+		err    string
+	}{
+		{"defaults", func(*StatementGenOptions) {}, ""},
+		{"reversed range", func(o *StatementGenOptions) { o.Start, o.End = o.End, o.Start }, "invalid time range"},
+		{"skew", func(o *StatementGenOptions) { o.Skew = 1 }, "skew must be > 1"},
+		{"rates", func(o *StatementGenOptions) { o.ErrorRate, o.RunningRate = 0.6, 0.5 }, "add up to at most 1"},
+		{"stats version", func(o *StatementGenOptions) { o.StatsVersions = []int{5} }, "unknown stats version 5"},
+	} {
+		opts := genOptions(1, 10)
+		tc.modify(&opts)
+		_, err := NewStatementGenerator(opts)
+		if (tc.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+const (
+	defaultErrorBucket  = 5 * time.Minute
+	defaultErrorTopN    = 5
+	defaultErrorSamples = 3
+)
+
+type ErrorAnalyticsRequest struct {
+	// Account limits the analytics to one account, empty means all.
+	Account string
+	Start   time.Time
+	End     time.Time
+	// Bucket is the width of the time buckets, at least one second.
+	Bucket time.Duration
+	// TopN is the number of accounts, users and fingerprints kept per error code.
+	TopN int
+	// Samples is the number of statement ids kept per error message.
+	Samples int
+}
+
+type NamedCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+type ErrorBucket struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// ErrorMessageStats groups the failed statements of one error code by normalized message.
+type ErrorMessageStats struct {
+	Message            string        `json:"message"`
+	Count              int64         `json:"count"`
+	Buckets            []ErrorBucket `json:"buckets"`
+	SampleStatementIds []string      `json:"sample_statement_ids"`
+}
+
+type ErrorCodeStats struct {
+	ErrCode         string              `json:"error_code"`
+	Count           int64               `json:"count"`
+	TopAccounts     []NamedCount        `json:"top_accounts"`
+	TopUsers        []NamedCount        `json:"top_users"`
+	TopFingerprints []NamedCount        `json:"top_fingerprints"`
+	Messages        []ErrorMessageStats `json:"messages"`
+}
+
+type errorBucketRow struct {
+	ErrCode  string
+	Error    string
+	Bucket   int64
+	Cnt      int64
+	SampleId string
+}
+
+type errorDimRow struct {
+	ErrCode string
+	Name    string
+	Cnt     int64
+}
+
+// errorNormalizers strip the variable parts out of error messages, the order matters:
+// quoted text may contain numbers, uuids contain hex digits.
+var errorNormalizers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`'(?:[^'\\]|\\.)*'`), "?"},
+	{regexp.MustCompile(`"(?:[^"\\]|\\.)*"`), "?"},
+	{regexp.MustCompile("`(?:[^`])*`"), "?"},
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "?"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "?"},
+	{regexp.MustCompile(`\b\d+(?:\.\d+)?\b`), "?"},
+	{regexp.MustCompile(`\s+`), " "},
+}
+
+// NormalizeErrorMessage replaces literals, identifiers and numbers in msg with '?'.
+func NormalizeErrorMessage(msg string) string {
+	for _, n := range errorNormalizers {
+		msg = n.re.ReplaceAllString(msg, n.repl)
+	}
+	return strings.TrimSpace(msg)
+}
+
+// SelectErrorAnalytics buckets the failed statements in [Start, End) by err_code,
+// normalized error message and time. The database groups by the raw message,
+// normalizing and merging happens here.
+func SelectErrorAnalytics(db *gorm.DB, req ErrorAnalyticsRequest, sqlComment string) ([]ErrorCodeStats, error) {
+	if req.Start.IsZero() || req.End.IsZero() || !req.Start.Before(req.End) {
+		return nil, errors.New("Invalid time range")
+	}
+	if req.Bucket == 0 {
+		req.Bucket = defaultErrorBucket
+	}
+	if req.Bucket < time.Second {
+		return nil, errors.New("Invalid bucket")
+	}
+	if req.TopN <= 0 {
+		req.TopN = defaultErrorTopN
+	}
+	if req.Samples <= 0 {
+		req.Samples = defaultErrorSamples
+	}
+
+	cond := "status = ? and request_at >= ? and request_at < ?"
+	args := []any{failedStatus, req.Start, req.End}
+	if req.Account != "" {
+		cond += " and account = ?"
+		args = append(args, req.Account)
+	}
+	step := int64(req.Bucket / time.Second)
+	bucketExpr := fmt.Sprintf("CAST(floor(unix_timestamp(request_at)/%d)*%d AS BIGINT)", step, step)
+
+	var (
+		buckets              []errorBucketRow
+		accounts, users, fps []errorDimRow
+		eg                   errgroup.Group
+	)
+	eg.Go(func() error {
+		return db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).
+			Select(fmt.Sprintf("err_code, error, %s AS bucket, count(*) AS cnt, any_value(statement_id) AS sample_id", bucketExpr)).
+			Where(cond, args...).Group("err_code, error, " + bucketExpr).Scan(&buckets).Error
+	})
+	selectDim := func(col string, dest *[]errorDimRow) {
+		eg.Go(func() error {
+			return db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).
+				Select(fmt.Sprintf("err_code, %s AS name, count(*) AS cnt", col)).
+				Where(cond, args...).Group("err_code, " + col).Order("cnt DESC").Scan(dest).Error
+		})
+	}
+	selectDim("account", &accounts)
+	selectDim("`user`", &users)
+	selectDim("statement_fingerprint", &fps)
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	codes := make(map[string]*ErrorCodeStats)
+	messages := make(map[string]map[string]*ErrorMessageStats)
+	for _, row := range buckets {
+		code, ok := codes[row.ErrCode]
+		if !ok {
+			code = &ErrorCodeStats{ErrCode: row.ErrCode}
+			codes[row.ErrCode] = code
+			messages[row.ErrCode] = make(map[string]*ErrorMessageStats)
+		}
+		code.Count += row.Cnt
+
+		normalized := NormalizeErrorMessage(row.Error)
+		msg, ok := messages[row.ErrCode][normalized]
+		if !ok {
+			msg = &ErrorMessageStats{Message: normalized}
+			messages[row.ErrCode][normalized] = msg
+		}
+		msg.Count += row.Cnt
+		msg.Buckets = addErrorBucket(msg.Buckets, time.Unix(row.Bucket, 0), row.Cnt)
+		if len(msg.SampleStatementIds) < req.Samples && row.SampleId != "" {
+			msg.SampleStatementIds = append(msg.SampleStatementIds, row.SampleId)
+		}
+	}
+	for _, dim := range []struct {
+		rows []errorDimRow
+		set  func(*ErrorCodeStats, NamedCount)
+	}{
+		{accounts, func(c *ErrorCodeStats, n NamedCount) { c.TopAccounts = append(c.TopAccounts, n) }},
+		{users, func(c *ErrorCodeStats, n NamedCount) { c.TopUsers = append(c.TopUsers, n) }},
+		{fps, func(c *ErrorCodeStats, n NamedCount) { c.TopFingerprints = append(c.TopFingerprints, n) }},
+	} {
+		// rows come sorted by count, keep the first TopN of every code
+		seen := make(map[string]int)
+		for _, row := range dim.rows {
+			code, ok := codes[row.ErrCode]
+			if !ok || seen[row.ErrCode] >= req.TopN {
+				continue
+			}
+			seen[row.ErrCode]++
+			dim.set(code, NamedCount{Name: row.Name, Count: row.Cnt})
+		}
+	}
+
+	result := make([]ErrorCodeStats, 0, len(codes))
+	for errCode, code := range codes {
+		for _, msg := range messages[errCode] {
+			sort.Slice(msg.Buckets, func(i, j int) bool { return msg.Buckets[i].Start.Before(msg.Buckets[j].Start) })
+			code.Messages = append(code.Messages, *msg)
+		}
+		sort.Slice(code.Messages, func(i, j int) bool { return code.Messages[i].Count > code.Messages[j].Count })
+		result = append(result, *code)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].ErrCode < result[j].ErrCode
+	})
+	return result, nil
+}
+
+func addErrorBucket(buckets []ErrorBucket, start time.Time, cnt int64) []ErrorBucket {
+	for i := range buckets {
+		if buckets[i].Start.Equal(start) {
+			buckets[i].Count += cnt
+			return buckets
+		}
+	}
+	return append(buckets, ErrorBucket{Start: start, Count: cnt})
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNormalizeErrorMessage(t *testing.T) {
+	for _, tc := range []struct {
+		msg, want string
+	}{
+		{"table 'db1.t1' doesn't exist", "table ? doesn't exist"},
+		{`Duplicate entry "42" for key 'pk'`, "Duplicate entry ? for key ?"},
+		{`unknown column "a\"b" in 'field list'`, "unknown column ? in ?"},
+		{"invalid input: column `c1` is ambiguous", "invalid input: column ? is ambiguous"},
+		{"txn 018eb819-4048-7e69-aaa6-feb99965eb97 conflicts", "txn ? conflicts"},
+		{"Data truncation: 3.14 out of range for row 12", "Data truncation: ? out of range for row ?"},
+		{"invalid value 0x1F", "invalid value ?"},
+		{"quoted 'has 12 and \\' quote' and 7", "quoted ? and ?"},
+		{"  deadlock\n\tdetected   ", "deadlock detected"},
+		{"table t1 not found", "table t1 not found"},
+		{"", ""},
+	} {
+		if got := NormalizeErrorMessage(tc.msg); got != tc.want {
+			t.Errorf("NormalizeErrorMessage(%q) = %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportJSONL   ExportFormat = "jsonl"
+	ExportParquet ExportFormat = "parquet"
+)
+
+type ExportCompression string
+
+const (
+	CompressionNone ExportCompression = ""
+	CompressionGzip ExportCompression = "gzip"
+	CompressionZstd ExportCompression = "zstd"
+)
+
+const defaultExportChunkSize = 5000
+
+// exportColumns is the projection used by ExportStatements, exec_plan is left out
+// since it easily dominates the size of a row.
+var exportColumns = []string{
+	"statement_id", "transaction_id", "session_id", "account", "`user`", "host", "`database`",
+	"statement", "statement_tag", "statement_fingerprint", "node_uuid", "node_type",
+	"request_at", "response_at", "duration", "status", "err_code", "error",
+	"rows_read", "bytes_scan", "stats", "statement_type", "query_type", "sql_source_type", "result_count",
+}
+
+// ExportRecord is the flat row written by ExportStatements.
+type ExportRecord struct {
+	StatementId          string    `parquet:"statement_id" json:"statement_id"`
+	TransactionId        string    `parquet:"transaction_id" json:"transaction_id"`
+	SessionId            string    `parquet:"session_id" json:"session_id"`
+	Account              string    `parquet:"account" json:"account"`
+	User                 string    `parquet:"user" json:"user"`
+	Host                 string    `parquet:"host" json:"host"`
+	Database             string    `parquet:"database" json:"database"`
+	Statement            string    `parquet:"statement" json:"statement"`
+	StatementTag         string    `parquet:"statement_tag" json:"statement_tag"`
+	StatementFingerprint string    `parquet:"statement_fingerprint" json:"statement_fingerprint"`
+	NodeUuid             string    `parquet:"node_uuid" json:"node_uuid"`
+	NodeType             string    `parquet:"node_type" json:"node_type"`
+	RequestAt            time.Time `parquet:"request_at,timestamp(microsecond)" json:"request_at"`
+	ResponseAt           time.Time `parquet:"response_at,timestamp(microsecond)" json:"response_at"`
+	Duration             uint64    `parquet:"duration" json:"duration"`
+	Status               string    `parquet:"status" json:"status"`
+	ErrCode              string    `parquet:"err_code" json:"error_code"`
+	Error                string    `parquet:"error" json:"error"`
+	RowsRead             uint64    `parquet:"rows_read" json:"rows_read"`
+	BytesScan            uint64    `parquet:"bytes_scan" json:"bytes_scan"`
+	Stats                string    `parquet:"stats" json:"stats"`
+	StatementType        string    `parquet:"statement_type" json:"statement_type"`
+	QueryType            string    `parquet:"query_type" json:"query_type"`
+	SqlSourceType        string    `parquet:"sql_source_type" json:"sql_source_type"`
+	ResultCount          int64     `parquet:"result_count" json:"result_count"`
+}
+
+var exportCSVHeader = []string{
+	"statement_id", "transaction_id", "session_id", "account", "user", "host", "database",
+	"statement", "statement_tag", "statement_fingerprint", "node_uuid", "node_type",
+	"request_at", "response_at", "duration", "status", "err_code", "error",
+	"rows_read", "bytes_scan", "stats", "statement_type", "query_type", "sql_source_type", "result_count",
+}
+
+func (r *ExportRecord) csvRecord() []string {
+	return []string{
+		r.StatementId, r.TransactionId, r.SessionId, r.Account, r.User, r.Host, r.Database,
+		r.Statement, r.StatementTag, r.StatementFingerprint, r.NodeUuid, r.NodeType,
+		r.RequestAt.Format(time.RFC3339Nano), r.ResponseAt.Format(time.RFC3339Nano),
+		strconv.FormatUint(r.Duration, 10), r.Status, r.ErrCode, r.Error,
+		strconv.FormatUint(r.RowsRead, 10), strconv.FormatUint(r.BytesScan, 10), r.Stats,
+		r.StatementType, r.QueryType, r.SqlSourceType, strconv.FormatInt(r.ResultCount, 10),
+	}
+}
+
+// ExportProgress is reported after each chunk is written.
+type ExportProgress struct {
+	Rows            int64
+	Chunks          int
+	LastRequestAt   time.Time
+	LastStatementId string
+}
+
+type ExportOptions struct {
+	// Account limits the export to one account, empty means all.
+	Account string
+	// Start and End select request_at in [Start, End).
+	Start time.Time
+	End   time.Time
+
+	Format      ExportFormat
+	Compression ExportCompression
+	// ChunkSize is the number of rows fetched per keyset query.
+	ChunkSize  int
+	SQLComment string
+	Progress   func(ExportProgress)
+}
+
+type exportWriter interface {
+	Write(r *ExportRecord) error
+	// Flush is called at the end of every chunk.
+	Flush() error
+	Close() error
+}
+
+// ExportStatements walks system.statement_info over [Start, End) ordered by
+// (request_at, statement_id), fetching ChunkSize rows per query, and writes
+// every row to w. Only one chunk is held by the driver at a time.
+func ExportStatements(ctx context.Context, db *gorm.DB, w io.Writer, opts ExportOptions) (int64, error) {
+	if opts.Start.IsZero() || opts.End.IsZero() || !opts.Start.Before(opts.End) {
+		return 0, errors.New("Invalid time range")
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultExportChunkSize
+	}
+	if opts.SQLComment == "" {
+		opts.SQLComment = NonUserRawComment
+	}
+
+	ew, err := newExportWriter(w, opts.Format, opts.Compression)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		progress ExportProgress
+		lastAt   time.Time
+		lastID   string
+	)
+	for {
+		query := db.WithContext(ctx).Clauses(hints.CommentBefore("SELECT", opts.SQLComment)).
+			Table(statementInfoDBTable).Select(exportColumns).
+			Where("request_at >= ? and request_at < ?", opts.Start, opts.End)
+		if opts.Account != "" {
+			query = query.Where("account = ?", opts.Account)
+		}
+		if lastID != "" {
+			query = query.Where("(request_at > ? or (request_at = ? and statement_id > ?))", lastAt, lastAt, lastID)
+		}
+		rows, err := query.Order("request_at, statement_id").Limit(opts.ChunkSize).Rows()
+		if err != nil {
+			ew.Close()
+			return progress.Rows, err
+		}
+
+		var n int
+		for rows.Next() {
+			var record ExportRecord
+			if err := query.ScanRows(rows, &record); err != nil {
+				rows.Close()
+				ew.Close()
+				return progress.Rows, err
+			}
+			if err := ew.Write(&record); err != nil {
+				rows.Close()
+				ew.Close()
+				return progress.Rows, err
+			}
+			lastAt, lastID = record.RequestAt, record.StatementId
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			ew.Close()
+			return progress.Rows, err
+		}
+		if n == 0 {
+			break
+		}
+		if err := ew.Flush(); err != nil {
+			ew.Close()
+			return progress.Rows, err
+		}
+
+		progress.Rows += int64(n)
+		progress.Chunks++
+		progress.LastRequestAt, progress.LastStatementId = lastAt, lastID
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+		if n < opts.ChunkSize {
+			break
+		}
+	}
+	return progress.Rows, ew.Close()
+}
+
+func newExportWriter(w io.Writer, format ExportFormat, compression ExportCompression) (exportWriter, error) {
+	// parquet compresses its pages itself, the others get the whole stream compressed.
+	if format == ExportParquet {
+		var codec parquet.WriterOption
+		switch compression {
+		case CompressionNone:
+		case CompressionGzip:
+			codec = parquet.Compression(&parquet.Gzip)
+		case CompressionZstd:
+			codec = parquet.Compression(&parquet.Zstd)
+		default:
+			return nil, fmt.Errorf("unknown compression: %s", compression)
+		}
+		return newParquetExportWriter(w, codec), nil
+	}
+
+	out, err := newCompressWriter(w, compression)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ExportCSV:
+		return newCSVExportWriter(out)
+	case ExportJSONL:
+		return newJSONLExportWriter(out), nil
+	default:
+		return nil, fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+func newCompressWriter(w io.Writer, compression ExportCompression) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("unknown compression: %s", compression)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type flusher interface {
+	Flush() error
+}
+
+// streamExportWriter handles buffering and compression for the line based formats.
+type streamExportWriter struct {
+	out io.WriteCloser
+	buf *bufio.Writer
+}
+
+func (s *streamExportWriter) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.out.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (s *streamExportWriter) close() error {
+	if err := s.buf.Flush(); err != nil {
+		s.out.Close()
+		return err
+	}
+	return s.out.Close()
+}
+
+type csvExportWriter struct {
+	streamExportWriter
+	csv *csv.Writer
+}
+
+func newCSVExportWriter(out io.WriteCloser) (*csvExportWriter, error) {
+	buf := bufio.NewWriter(out)
+	c := &csvExportWriter{streamExportWriter: streamExportWriter{out: out, buf: buf}, csv: csv.NewWriter(buf)}
+	if err := c.csv.Write(exportCSVHeader); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvExportWriter) Write(r *ExportRecord) error {
+	return c.csv.Write(r.csvRecord())
+}
+
+func (c *csvExportWriter) Flush() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+func (c *csvExportWriter) Close() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		c.out.Close()
+		return err
+	}
+	return c.close()
+}
+
+type jsonlExportWriter struct {
+	streamExportWriter
+	enc *json.Encoder
+}
+
+func newJSONLExportWriter(out io.WriteCloser) *jsonlExportWriter {
+	buf := bufio.NewWriter(out)
+	return &jsonlExportWriter{streamExportWriter: streamExportWriter{out: out, buf: buf}, enc: json.NewEncoder(buf)}
+}
+
+func (j *jsonlExportWriter) Write(r *ExportRecord) error {
+	return j.enc.Encode(r)
+}
+
+func (j *jsonlExportWriter) Flush() error {
+	return j.flush()
+}
+
+func (j *jsonlExportWriter) Close() error {
+	return j.close()
+}
+
+// parquetExportWriter writes one row group per chunk.
+type parquetExportWriter struct {
+	pw *parquet.GenericWriter[ExportRecord]
+}
+
+func newParquetExportWriter(w io.Writer, codec parquet.WriterOption) *parquetExportWriter {
+	var options []parquet.WriterOption
+	if codec != nil {
+		options = append(options, codec)
+	}
+	return &parquetExportWriter{pw: parquet.NewGenericWriter[ExportRecord](w, options...)}
+}
+
+func (p *parquetExportWriter) Write(r *ExportRecord) error {
+	_, err := p.pw.Write([]ExportRecord{*r})
+	return err
+}
+
+func (p *parquetExportWriter) Flush() error {
+	return p.pw.Flush()
+}
+
+func (p *parquetExportWriter) Close() error {
+	return p.pw.Close()
+}
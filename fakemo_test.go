@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gms "github.com/dolthub/go-mysql-server/sql"
+	gmstypes "github.com/dolthub/go-mysql-server/sql/types"
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	gmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	fakeMOUser     = "dump"
+	fakeMOPassword = "111"
+	// fakeMOFixtures has the rows the tests of the query layer expect
+	fakeMOFixtures = "testdata/statements.yaml"
+)
+
+// fakeMOSchema creates the tables the query layer reads. The columns follow MO, with
+// defaults so fixtures only need the columns they are about.
+var fakeMOSchema = []string{
+	"create database if not exists `system`",
+	"create database if not exists mo_catalog",
+	"create database if not exists " + metricsDatabase,
+	`create table if not exists ` + "`system`" + `.statement_info (
+		statement_id varchar(36) not null,
+		transaction_id varchar(36) not null default '',
+		session_id varchar(36) not null default '',
+		account varchar(1024) not null default '',
+		` + "`user`" + ` varchar(1024) not null default '',
+		host varchar(1024) not null default '',
+		` + "`database`" + ` varchar(1024) not null default '',
+		statement text,
+		statement_tag text,
+		statement_fingerprint text,
+		node_uuid varchar(36) not null default '',
+		node_type varchar(64) not null default '',
+		request_at datetime(6) not null,
+		response_at datetime(6) not null,
+		duration bigint unsigned not null default 0,
+		status varchar(32) not null default 'Success',
+		err_code varchar(1024) not null default '',
+		error text,
+		exec_plan json,
+		rows_read bigint unsigned not null default 0,
+		bytes_scan bigint unsigned not null default 0,
+		stats json,
+		statement_type varchar(128) not null default '',
+		query_type varchar(128) not null default '',
+		role_id bigint unsigned not null default 0,
+		sql_source_type text,
+		result_count bigint not null default 0,
+		primary key (statement_id)
+	)`,
+	`create table if not exists mo_catalog.statement_cu (
+		statement_id varchar(36) not null,
+		account varchar(300) not null default '',
+		response_at datetime(6) not null,
+		cu decimal(32,4) not null default 0,
+		account_id int unsigned not null default 0,
+		node_type varchar(64) not null default '',
+		primary key (statement_id)
+	)`,
+	// processlist() of MO is a table function, the fake reads this table instead
+	`create table if not exists mo_catalog.processlist (
+		conn_id bigint unsigned not null,
+		session_id varchar(36) not null default '',
+		account varchar(300) not null default '',
+		` + "`user`" + ` varchar(300) not null default '',
+		txn_id varchar(64) not null default '',
+		statement_id varchar(36) not null default '',
+		info text,
+		query_start varchar(64) not null default '',
+		primary key (conn_id)
+	)`,
+}
+
+// fakeMetricTables are the tables of system_metrics with their label columns.
+var fakeMetricTables = map[string][]string{
+	"sql_statement_total":          {"node", "role", "account", "type"},
+	"sql_statement_duration_total": {"node", "role", "account", "type"},
+	"sql_transaction_total":        {"node", "role", "account", "type"},
+	"sql_transaction_errors":       {"node", "role", "account", "type"},
+	"server_connections":           {"node", "role", "account"},
+	"process_cpu_percent":          {"node", "role"},
+}
+
+// FakeFunction is a MO function of the fake server, Fn gets the evaluated arguments,
+// nil for NULL.
+type FakeFunction struct {
+	// Type is the type of the result, like gmstypes.Float64.
+	Type gms.Type
+	Fn   func(args []any) (any, error)
+}
+
+// fakeFunctions are the default stubs of the MO functions.
+var fakeFunctions = map[string]FakeFunction{
+	// current_account_name() is sys, the account of fakeMOUser in MO.
+	"current_account_name": {Type: gmstypes.LongText, Fn: func(args []any) (any, error) {
+		return sysAccount, nil
+	}},
+	// mo_cu_v1(stats, duration) is the duration in seconds, not the MO formula.
+	"mo_cu_v1": {Type: gmstypes.Float64, Fn: func(args []any) (any, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("mo_cu_v1 takes 2 arguments, got %d", len(args))
+		}
+		if args[1] == nil {
+			return nil, nil
+		}
+		ns, _, err := gmstypes.Float64.Convert(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return ns.(float64) / 1e9, nil
+	}},
+}
+
+type FakeMOOptions struct {
+	// Functions replace or add to the default stubs.
+	Functions map[string]FakeFunction
+	// Fixtures are loaded after the tables are created, see LoadFixtures.
+	Fixtures []string
+	Logger   gormlogger.Interface
+	// Verbose keeps the go-mysql-server log, which otherwise only has errors.
+	Verbose bool
+}
+
+// FakeMO is an in-process MySQL protocol server with the MO tables the query layer reads,
+// so the query layer is tested without a MO cluster. It is go-mysql-server, a MySQL, so
+// besides the stubs of FakeMOOptions.Functions the MO specific syntax and functions are
+// missing.
+type FakeMO struct {
+	// Addr is the address the server listens on, a free port of 127.0.0.1.
+	Addr string
+
+	server *server.Server
+	db     *gorm.DB
+}
+
+// StartFakeMO creates the tables, starts serving and loads the fixtures, the server is
+// closed with the test. Any user and password is accepted.
+func StartFakeMO(t testing.TB, opts FakeMOOptions) *FakeMO {
+	t.Helper()
+	if opts.Logger == nil {
+		opts.Logger = gormlogger.Discard
+	}
+	if !opts.Verbose {
+		// the server logs with the standard logger of logrus
+		logrus.SetLevel(logrus.ErrorLevel)
+	}
+	engine := sqle.NewDefault(memory.NewDBProvider())
+	registerFakeFunctions(engine, opts.Functions)
+
+	srv, err := server.NewDefaultServer(server.Config{Protocol: "tcp", Address: "127.0.0.1:0"}, engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &FakeMO{Addr: srv.Listener.Addr().String(), server: srv}
+	go srv.Start()
+	t.Cleanup(f.Close)
+
+	if f.db, err = openFakeMODB(f.Config(), opts.Logger); err != nil {
+		t.Fatal(err)
+	}
+	for _, ddl := range fakeMOSchema {
+		if err := f.db.Exec(ddl).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, labels := range fakeMetricTables {
+		if err := f.CreateMetricTable(name, labels...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range opts.Fixtures {
+		if err := f.LoadFixtures(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func registerFakeFunctions(engine *sqle.Engine, extra map[string]FakeFunction) {
+	funcs := make(map[string]FakeFunction, len(fakeFunctions)+len(extra))
+	for name, fn := range fakeFunctions {
+		funcs[name] = fn
+	}
+	for name, fn := range extra {
+		funcs[strings.ToLower(name)] = fn
+	}
+	ctx := gms.NewEmptyContext()
+	for name, fn := range funcs {
+		name, fn := name, fn
+		engine.Analyzer.Catalog.RegisterFunction(ctx, gms.FunctionN{Name: name, Fn: func(args ...gms.Expression) (gms.Expression, error) {
+			return &fakeFuncExpr{name: name, fn: fn, args: args}, nil
+		}})
+	}
+}
+
+// Config connects to f, the Database is empty so the tables are used with their database.
+func (f *FakeMO) Config() Config {
+	host, port, _ := net.SplitHostPort(f.Addr)
+	p, _ := strconv.Atoi(port)
+	return Config{Host: host, Port: p, Username: fakeMOUser, Password: fakeMOPassword}
+}
+
+// DB is a connection to f which translates the MO dialect, see moDialectConnector.
+func (f *FakeMO) DB() *gorm.DB {
+	return f.db
+}
+
+func (f *FakeMO) Close() {
+	if f.db != nil {
+		if conn, err := f.db.DB(); err == nil {
+			conn.Close()
+		}
+	}
+	f.server.Close()
+}
+
+// openFakeMODB connects like OpenDB, through moDialectConnector.
+func openFakeMODB(cfg Config, logger gormlogger.Interface) (*gorm.DB, error) {
+	mysqlCfg := mysql.NewConfig()
+	mysqlCfg.User = cfg.Username
+	mysqlCfg.Passwd = cfg.Password
+	mysqlCfg.Addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	mysqlCfg.AllowNativePasswords = true
+	mysqlCfg.ParseTime = true
+	mysqlCfg.Timeout = 10 * time.Second
+	connector, err := mysql.NewConnector(mysqlCfg)
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(gmysql.New(gmysql.Config{
+		Conn: sql.OpenDB(moDialectConnector{connector}),
+	}), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{
+			SingularTable: true,
+		},
+		Logger:                                   logger,
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+}
+
+// CreateMetricTable adds a table to system_metrics with the label columns besides
+// collecttime and value.
+func (f *FakeMO) CreateMetricTable(name string, labels ...string) error {
+	if !identRegexp.MatchString(name) {
+		return fmt.Errorf("invalid metric table %q", name)
+	}
+	cols := []string{"`" + metricTimeCol + "` datetime(6) not null", "`" + metricValueCol + "` double not null default 0"}
+	for _, l := range labels {
+		if !identRegexp.MatchString(l) {
+			return fmt.Errorf("invalid label %q", l)
+		}
+		cols = append(cols, "`"+l+"` varchar(1024) not null default ''")
+	}
+	return f.db.Exec(fmt.Sprintf("create table if not exists %s.`%s` (%s)", metricsDatabase, name, strings.Join(cols, ", "))).Error
+}
+
+// Insert adds rows to table, the columns missing from a row get their default.
+// Maps and slices, as read from yaml, are written as json.
+func (f *FakeMO) Insert(table string, rows ...map[string]any) error {
+	for _, row := range rows {
+		values := make(map[string]any, len(row))
+		for col, v := range row {
+			switch v.(type) {
+			case map[string]any, []any:
+				data, err := json.Marshal(v)
+				if err != nil {
+					return fmt.Errorf("%s.%s: %w", table, col, err)
+				}
+				v = string(data)
+			}
+			values[col] = v
+		}
+		if err := f.db.Table(table).Create(values).Error; err != nil {
+			return fmt.Errorf("insert into %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// InsertStatements adds records to system.statement_info, CU is left out since it is not a column.
+func (f *FakeMO) InsertStatements(records ...StatementInfo) error {
+	rows := make([]map[string]any, 0, len(records))
+	for _, s := range records {
+		row := map[string]any{
+			"statement_id":          s.StatementId,
+			"transaction_id":        s.TransactionId,
+			"session_id":            s.SessionId,
+			"account":               s.Account,
+			"user":                  s.User,
+			"host":                  s.Host,
+			"database":              s.Database,
+			"statement":             s.Statement,
+			"statement_tag":         s.StatementTag,
+			"statement_fingerprint": s.StatementFingerprint,
+			"node_uuid":             s.NodeUuid,
+			"node_type":             s.NodeType,
+			"request_at":            s.RequestAt,
+			"response_at":           s.ResponseAt,
+			"duration":              s.Duration,
+			"status":                s.Status,
+			"err_code":              s.ErrCode,
+			"error":                 s.Error,
+			"statement_type":        s.StatementType,
+			"query_type":            s.QueryType,
+			"sql_source_type":       s.SqlSourceType,
+			"result_count":          s.ResultCount,
+		}
+		if s.ExecPlan != "" {
+			row["exec_plan"] = s.ExecPlan
+		}
+		if s.Status == "" {
+			delete(row, "status")
+		}
+		for col, v := range map[string]*uint64{"rows_read": s.RowsRead, "bytes_scan": s.BytesScan, "role_id": s.RoleId} {
+			if v != nil {
+				row[col] = *v
+			}
+		}
+		if st := s.Stats; st != nil {
+			// stats is a json array in MO, in the order of the fields of Stats
+			row["stats"] = []any{st.Version, st.TimeConsumed, st.MemorySize, st.S3IOInput, st.S3IOOutput, st.NetworkIO, float64(st.ConnType)}
+		}
+		rows = append(rows, row)
+	}
+	return f.Insert(statementInfoDBTable, rows...)
+}
+
+// LoadFixtures inserts the rows of a yaml file, keyed by the table with its database:
+//
+//	system.statement_info:
+//	  - {statement_id: 018eb819-4048-7e69-aaa6-feb99965eb97, account: sys, request_at: "2024-03-25 18:40:16", ...}
+//	system_metrics.sql_statement_total:
+//	  - {collecttime: "2024-03-25 18:40:00", value: 3, node: cn0, type: select}
+func (f *FakeMO) LoadFixtures(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var fixtures map[string][]map[string]any
+	if err := yaml.Unmarshal(data, &fixtures); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	for table, rows := range fixtures {
+		if err := f.Insert(table, rows...); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// moRewrites turn the MO dialect into the MySQL of go-mysql-server.
+var moRewrites = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// MO takes the system database as is, system is a keyword of go-mysql-server
+	{regexp.MustCompile("(?i)(^|[^\\w.`])system\\."), "${1}`system`."},
+	// which only parses a column qualified with it quoted when the column is a keyword like account
+	{regexp.MustCompile("`system`\\.(\\w+)\\.(\\w+)"), "`system`.$1.`$2`"},
+	// the table functions of MO are tables of the fake, see fakeMOSchema
+	{regexp.MustCompile(`(?i)\bprocesslist\(\)`), "mo_catalog.processlist"},
+	// MO names the statement to kill after the connection
+	{regexp.MustCompile(`(?i)\bkill\s+query\s+(\d+)\s+"[^"]*"`), "KILL QUERY $1"},
+	// MO casts to the integer types by name
+	{regexp.MustCompile(`(?i)\bas\s+(bigint|int)\s*\)`), "AS SIGNED)"},
+	// go-mysql-server resolves no column qualified with its database in ORDER BY
+	{regexp.MustCompile("(?i)\\border\\s+by\\s+`system`\\.(\\w+)\\."), "ORDER BY $1."},
+	// and types IF by its second argument, the NULL of a running statement nulls every CU
+	{regexp.MustCompile(`(?i)\bIF\((\s*status\s*=\s*'Running'\s*),\s*NULL\s*,`), "IF($1, CAST(NULL AS DECIMAL(32,4)),"},
+}
+
+func rewriteMOQuery(query string) string {
+	for _, r := range moRewrites {
+		query = r.re.ReplaceAllString(query, r.repl)
+	}
+	return query
+}
+
+// moDialectConnector rewrites the MO dialect of every statement before the driver sends
+// or prepares it, go-mysql-server has no hook before its parser. The rewrite does not
+// skip string literals, and the columns of information_schema keep their upper case.
+type moDialectConnector struct {
+	driver.Connector
+}
+
+func (c moDialectConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return moDialectConn{conn}, nil
+}
+
+// moDialectConn forwards to the mysql driver, which implements all of these.
+type moDialectConn struct {
+	driver.Conn
+}
+
+func (c moDialectConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rewriteMOQuery(query))
+}
+
+func (c moDialectConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, rewriteMOQuery(query))
+}
+
+func (c moDialectConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c moDialectConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, rewriteMOQuery(query), args)
+}
+
+func (c moDialectConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, rewriteMOQuery(query), args)
+}
+
+func (c moDialectConn) CheckNamedValue(v *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(v)
+}
+
+func (c moDialectConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c moDialectConn) IsValid() bool {
+	return c.Conn.(driver.Validator).IsValid()
+}
+
+// fakeFuncExpr evaluates a FakeFunction.
+type fakeFuncExpr struct {
+	name string
+	fn   FakeFunction
+	args []gms.Expression
+}
+
+var _ gms.FunctionExpression = (*fakeFuncExpr)(nil)
+
+func (e *fakeFuncExpr) Resolved() bool {
+	for _, a := range e.args {
+		if !a.Resolved() {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *fakeFuncExpr) String() string {
+	args := make([]string, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.String()
+	}
+	return e.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e *fakeFuncExpr) Type() gms.Type {
+	return e.fn.Type
+}
+
+func (e *fakeFuncExpr) IsNullable() bool {
+	return true
+}
+
+func (e *fakeFuncExpr) Eval(ctx *gms.Context, row gms.Row) (interface{}, error) {
+	args := make([]any, len(e.args))
+	for i, a := range e.args {
+		v, err := a.Eval(ctx, row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return e.fn.Fn(args)
+}
+
+func (e *fakeFuncExpr) Children() []gms.Expression {
+	return e.args
+}
+
+func (e *fakeFuncExpr) WithChildren(children ...gms.Expression) (gms.Expression, error) {
+	if len(children) != len(e.args) {
+		return nil, gms.ErrInvalidChildrenNumber.New(e, len(children), len(e.args))
+	}
+	return &fakeFuncExpr{name: e.name, fn: e.fn, args: children}, nil
+}
+
+func (e *fakeFuncExpr) FunctionName() string {
+	return e.name
+}
+
+func (e *fakeFuncExpr) Description() string {
+	return "stub of the MO function " + e.name
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type OutputFormat string
+
+const (
+	OutputTable    OutputFormat = "table"
+	OutputJSON     OutputFormat = "json"
+	OutputNDJSON   OutputFormat = "ndjson"
+	OutputCSV      OutputFormat = "csv"
+	OutputYAML     OutputFormat = "yaml"
+	OutputMarkdown OutputFormat = "markdown"
+)
+
+func (f OutputFormat) valid() bool {
+	switch f {
+	case OutputTable, OutputJSON, OutputNDJSON, OutputCSV, OutputYAML, OutputMarkdown:
+		return true
+	}
+	return false
+}
+
+const (
+	defaultStatementWidth = 60
+	defaultColumnWidth    = 40
+)
+
+// durationColumns hold nanoseconds, like statement_info.duration.
+var durationColumns = map[string]bool{
+	"duration":     true,
+	"p50":          true,
+	"p95":          true,
+	"p99":          true,
+	"max_duration": true,
+	"wait_time":    true,
+	"holder_age":   true,
+	"elapsed":      true,
+}
+
+var byteColumns = map[string]bool{
+	"bytes_scan":  true,
+	"memory_size": true,
+}
+
+type RenderOptions struct {
+	Format OutputFormat
+	// StatementWidth truncates the statement column of table and markdown, 0 means the default.
+	StatementWidth int
+	// ColumnWidth truncates the other text columns of table and markdown, 0 means the default.
+	ColumnWidth int
+	// Raw keeps nanoseconds and bytes as numbers in table and markdown.
+	Raw bool
+}
+
+// statementWidth is the width Render truncates the statement column to.
+func (o RenderOptions) statementWidth() int {
+	switch {
+	case o.StatementWidth <= 0:
+		return defaultStatementWidth
+	case o.StatementWidth < 4:
+		return 4
+	}
+	return o.StatementWidth
+}
+
+// Render writes v, a struct or a slice of structs returned by the query layer, in the given format.
+// json, ndjson and yaml keep the json field names and raw values; table, csv and markdown flatten
+// v into rows first, with a column per label for the metric series.
+func Render(w io.Writer, v any, opts RenderOptions) error {
+	if opts.StatementWidth <= 0 {
+		opts.StatementWidth = defaultStatementWidth
+	}
+	if opts.ColumnWidth <= 0 {
+		opts.ColumnWidth = defaultColumnWidth
+	}
+	switch opts.Format {
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputNDJSON:
+		return renderNDJSON(w, v)
+	case OutputYAML:
+		return renderYAML(w, v)
+	case OutputTable, "":
+		t, err := tabulate(v)
+		if err != nil {
+			return err
+		}
+		return t.writeTable(w, opts)
+	case OutputCSV:
+		t, err := tabulate(v)
+		if err != nil {
+			return err
+		}
+		return t.writeCSV(w)
+	case OutputMarkdown:
+		t, err := tabulate(v)
+		if err != nil {
+			return err
+		}
+		return t.writeMarkdown(w, opts)
+	default:
+		return fmt.Errorf("unknown output format: %s", opts.Format)
+	}
+}
+
+// renderNDJSON writes one line per element of a slice, other values as a single line.
+func renderNDJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice {
+		return enc.Encode(v)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderYAML goes through json so the keys and omitempty follow the json tags.
+func renderYAML(w io.Writer, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(yamlNumbers(generic)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// yamlNumbers turns the json.Number of a decoded value into int64 or float64,
+// so large integers like durations are not written in exponent form.
+func yamlNumbers(v any) any {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case []any:
+		for i := range x {
+			x[i] = yamlNumbers(x[i])
+		}
+	case map[string]any:
+		for k := range x {
+			x[k] = yamlNumbers(x[k])
+		}
+	}
+	return v
+}
+
+// table is v flattened into rows, the cells keep their Go values until rendered.
+type table struct {
+	columns []string
+	rows    [][]any
+	// sqlResult keeps the empty columns and shows nil as NULL, like the mysql client.
+	sqlResult bool
+}
+
+func tabulate(v any) (*table, error) {
+	switch s := v.(type) {
+	case *ResultSet:
+		return &table{columns: s.Columns, rows: s.Rows, sqlResult: true}, nil
+	case []MetricSeries:
+		return tabulateSeries(len(s), func(i int) (map[string]string, []MetricPoint, []any, []string) {
+			return s[i].Labels, s[i].Points, nil, nil
+		}), nil
+	case []QuantileSeries:
+		return tabulateSeries(len(s), func(i int) (map[string]string, []MetricPoint, []any, []string) {
+			return s[i].Labels, s[i].Points, []any{s[i].Quantile}, []string{"quantile"}
+		}), nil
+	case []VectorSample:
+		series := make([]MetricSeries, len(s))
+		for i, sample := range s {
+			series[i] = MetricSeries{Labels: sample.Labels, Points: []MetricPoint{{Timestamp: sample.Timestamp, Value: sample.Value}}}
+		}
+		return tabulate(series)
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return &table{}, nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		t := &table{columns: structColumns(rv.Type())}
+		t.rows = append(t.rows, structRow(rv))
+		return t, nil
+	case reflect.Slice, reflect.Array:
+		elem := rv.Type().Elem()
+		for elem.Kind() == reflect.Pointer {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct {
+			t := &table{columns: []string{"value"}}
+			for i := 0; i < rv.Len(); i++ {
+				t.rows = append(t.rows, []any{rv.Index(i).Interface()})
+			}
+			return t, nil
+		}
+		t := &table{columns: structColumns(elem)}
+		for i := 0; i < rv.Len(); i++ {
+			item := reflect.Indirect(rv.Index(i))
+			if !item.IsValid() {
+				continue
+			}
+			t.rows = append(t.rows, structRow(item))
+		}
+		return t, nil
+	default:
+		return &table{columns: []string{"value"}, rows: [][]any{{rv.Interface()}}}, nil
+	}
+}
+
+// tabulateSeries writes one row per point with a column per label.
+func tabulateSeries(n int, get func(i int) (map[string]string, []MetricPoint, []any, []string)) *table {
+	labelSet := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		labels, _, _, _ := get(i)
+		for name := range labels {
+			labelSet[name] = true
+		}
+	}
+	labelNames := make([]string, 0, len(labelSet))
+	for name := range labelSet {
+		labelNames = append(labelNames, name)
+	}
+	sort.Strings(labelNames)
+
+	t := &table{}
+	for i := 0; i < n; i++ {
+		labels, points, extra, extraCols := get(i)
+		if t.columns == nil {
+			t.columns = append(append(append([]string{}, extraCols...), labelNames...), "timestamp", "value")
+		}
+		for _, p := range points {
+			row := append([]any{}, extra...)
+			for _, name := range labelNames {
+				row = append(row, labels[name])
+			}
+			var value any = p.Value
+			if p.Null {
+				value = nil
+			}
+			row = append(row, time.Unix(p.Timestamp, 0), value)
+			t.rows = append(t.rows, row)
+		}
+	}
+	if t.columns == nil {
+		t.columns = append(labelNames, "timestamp", "value")
+	}
+	return t
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+func structColumns(t reflect.Type) []string {
+	var cols []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || jsonName(f) == "-" {
+			continue
+		}
+		cols = append(cols, jsonName(f))
+	}
+	return cols
+}
+
+func structRow(v reflect.Value) []any {
+	var row []any
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() || jsonName(f) == "-" {
+			continue
+		}
+		row = append(row, v.Field(i).Interface())
+	}
+	return row
+}
+
+// nonEmptyColumns drops the columns that are empty in every row, the projections
+// of StatementInfo leave most of its fields unset.
+func (t *table) nonEmptyColumns() *table {
+	if t.sqlResult {
+		return t
+	}
+	keep := make([]bool, len(t.columns))
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if !keep[i] && formatCell(t.columns[i], cell, true) != "" {
+				keep[i] = true
+			}
+		}
+	}
+	out := &table{}
+	for i, col := range t.columns {
+		if keep[i] || len(t.rows) == 0 {
+			out.columns = append(out.columns, col)
+		}
+	}
+	for _, row := range t.rows {
+		var r []any
+		for i, cell := range row {
+			if keep[i] {
+				r = append(r, cell)
+			}
+		}
+		out.rows = append(out.rows, r)
+	}
+	return out
+}
+
+// formatCell renders one value, human turns nanoseconds and bytes into readable units.
+func formatCell(col string, v any, human bool) string {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return ""
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	switch x := rv.Interface().(type) {
+	case time.Time:
+		if x.IsZero() {
+			return ""
+		}
+		if human {
+			return x.Format(time.DateTime)
+		}
+		return x.Format(time.RFC3339Nano)
+	case string:
+		return x
+	case []string:
+		return strings.Join(x, ",")
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return formatNumber(col, float64(rv.Int()), strconv.FormatInt(rv.Int(), 10), human)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return formatNumber(col, float64(rv.Uint()), strconv.FormatUint(rv.Uint(), 10), human)
+	case reflect.Float32, reflect.Float64:
+		return formatNumber(col, rv.Float(), strconv.FormatFloat(rv.Float(), 'f', -1, 64), human)
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0 {
+			return ""
+		}
+		data, err := json.Marshal(rv.Interface())
+		if err != nil {
+			return fmt.Sprint(rv.Interface())
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(rv.Interface())
+	}
+}
+
+func formatNumber(col string, v float64, plain string, human bool) string {
+	switch {
+	case human && durationColumns[col]:
+		return humanDuration(time.Duration(v))
+	case human && byteColumns[col]:
+		return humanBytes(v)
+	case human && (col == "value" || col == "cu" || col == "share" || col == "error_rate" || col == "qps"):
+		return strconv.FormatFloat(v, 'g', 6, 64)
+	default:
+		return plain
+	}
+}
+
+// humanDuration keeps about three significant digits, like 1.23s or 45.6ms.
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= 10*time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	case d >= time.Microsecond:
+		return d.Round(10 * time.Nanosecond).String()
+	default:
+		return d.String()
+	}
+}
+
+func humanBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatFloat(n, 'f', -1, 64) + " B"
+	}
+	exp := 0
+	for n >= unit && exp < 5 {
+		n /= unit
+		exp++
+	}
+	return strconv.FormatFloat(n, 'f', 1, 64) + " " + string("KMGTP"[exp-1]) + "iB"
+}
+
+// displayCell formats a cell for table and markdown, on a single line and truncated.
+func (t *table) displayCell(col string, v any, opts RenderOptions) string {
+	if t.sqlResult && v == nil {
+		return "NULL"
+	}
+	s := formatCell(col, v, !opts.Raw && !t.sqlResult)
+	width := opts.ColumnWidth
+	if col == "statement" || col == "statement_fingerprint" {
+		width = opts.StatementWidth
+	}
+	if width < 4 {
+		width = 4
+	}
+	if strings.ContainsAny(s, "\t\r\n") || len([]rune(s)) > width {
+		s = truncateStatement(s, width)
+	}
+	return s
+}
+
+func (t *table) writeTable(w io.Writer, opts RenderOptions) error {
+	t = t.nonEmptyColumns()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(t.columns, "\t")))
+	for _, row := range t.rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = t.displayCell(t.columns[i], cell, opts)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (t *table) writeMarkdown(w io.Writer, opts RenderOptions) error {
+	t = t.nonEmptyColumns()
+	var buf bytes.Buffer
+	escape := strings.NewReplacer("|", `\|`)
+	buf.WriteString("| " + strings.Join(t.columns, " | ") + " |\n")
+	buf.WriteString("|" + strings.Repeat(" --- |", len(t.columns)) + "\n")
+	for _, row := range t.rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escape.Replace(t.displayCell(t.columns[i], cell, opts))
+		}
+		buf.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeCSV keeps every column and the raw values, so the output can be loaded back.
+func (t *table) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.columns); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = formatCell(t.columns[i], cell, false)
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type FillPolicy string
+
+const (
+	// FillNone keeps only the buckets that have samples.
+	FillNone     FillPolicy = ""
+	FillZero     FillPolicy = "zero"
+	FillNull     FillPolicy = "null"
+	FillPrevious FillPolicy = "previous"
+	// FillLinear interpolates between the surrounding points, leading and
+	// trailing gaps are left null.
+	FillLinear FillPolicy = "linear"
+)
+
+func (f FillPolicy) valid() bool {
+	switch f {
+	case FillNone, FillZero, FillNull, FillPrevious, FillLinear:
+		return true
+	}
+	return false
+}
+
+// MarshalJSON writes a null value for the points added by FillNull.
+func (p MetricPoint) MarshalJSON() ([]byte, error) {
+	if p.Null {
+		return []byte(fmt.Sprintf(`{"timestamp":%d,"value":null}`, p.Timestamp)), nil
+	}
+	type point MetricPoint
+	return json.Marshal(point(p))
+}
+
+// AlignStep returns the first and last bucket of the step grid covering [start, end).
+// Buckets start at multiples of step since the unix epoch, like the buckets of MetricQuery.
+func AlignStep(start, end time.Time, step time.Duration) (int64, int64) {
+	s := int64(step / time.Second)
+	first := floorDiv(start.Unix(), s) * s
+	last := floorDiv(end.Unix()-1, s) * s
+	if end.Nanosecond() > 0 {
+		last = floorDiv(end.Unix(), s) * s
+	}
+	return first, last
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// FillSeries returns points on every bucket of the step grid over [start, end),
+// missing buckets are filled according to policy. points must be sorted and aligned.
+func FillSeries(points []MetricPoint, start, end time.Time, step time.Duration, policy FillPolicy) []MetricPoint {
+	if policy == FillNone {
+		return points
+	}
+	first, last := AlignStep(start, end, step)
+	s := int64(step / time.Second)
+	if last < first {
+		return []MetricPoint{}
+	}
+	filled := make([]MetricPoint, 0, (last-first)/s+1)
+	i := 0
+	for ts := first; ts <= last; ts += s {
+		for i < len(points) && points[i].Timestamp < ts {
+			i++
+		}
+		if i < len(points) && points[i].Timestamp == ts {
+			filled = append(filled, points[i])
+			continue
+		}
+		p := MetricPoint{Timestamp: ts}
+		switch policy {
+		case FillZero:
+		case FillNull:
+			p.Null = true
+		case FillPrevious:
+			if n := len(filled); n > 0 && !filled[n-1].Null {
+				p.Value = filled[n-1].Value
+			} else {
+				p.Null = true
+			}
+		case FillLinear:
+			p.Null = true
+			if i > 0 && i < len(points) {
+				prev, next := points[i-1], points[i]
+				ratio := float64(ts-prev.Timestamp) / float64(next.Timestamp-prev.Timestamp)
+				p.Value, p.Null = prev.Value+(next.Value-prev.Value)*ratio, false
+			}
+		}
+		filled = append(filled, p)
+	}
+	return filled
+}
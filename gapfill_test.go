@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlignStep(t *testing.T) {
+	at := func(sec int64, nsec int64) time.Time { return time.Unix(sec, nsec) }
+	for _, tc := range []struct {
+		start, end  time.Time
+		first, last int64
+	}{
+		{at(0, 0), at(300, 0), 0, 240},
+		{at(30, 0), at(270, 0), 0, 240},
+		{at(60, 0), at(300, 1), 60, 300},
+		{at(59, 999), at(61, 0), 0, 60},
+		{at(-30, 0), at(30, 0), -60, 0},
+		{at(120, 0), at(120, 0), 120, 60},
+	} {
+		first, last := AlignStep(tc.start, tc.end, time.Minute)
+		if first != tc.first || last != tc.last {
+			t.Errorf("AlignStep(%d, %d) = %d, %d, want %d, %d",
+				tc.start.UnixNano(), tc.end.UnixNano(), first, last, tc.first, tc.last)
+		}
+	}
+}
+
+func TestFillSeries(t *testing.T) {
+	points := func(kv ...float64) []MetricPoint {
+		var ps []MetricPoint
+		for i := 0; i < len(kv); i += 2 {
+			ps = append(ps, MetricPoint{Timestamp: int64(kv[i]), Value: kv[i+1]})
+		}
+		return ps
+	}
+	// leading gap at 0, a gap at 120 and a trailing gap at 240
+	gaps := points(60, 1, 180, 3)
+	single := points(120, 5)
+	for _, tc := range []struct {
+		name       string
+		points     []MetricPoint
+		start, end float64
+		policy     FillPolicy
+		want       string
+	}{
+		{"none", gaps, 0, 300, FillNone, "60:1 180:3"},
+		{"zero", gaps, 0, 300, FillZero, "0:0 60:1 120:0 180:3 240:0"},
+		{"null", gaps, 0, 300, FillNull, "0:null 60:1 120:null 180:3 240:null"},
+		{"previous", gaps, 0, 300, FillPrevious, "0:null 60:1 120:1 180:3 240:3"},
+		{"linear", gaps, 0, 300, FillLinear, "0:null 60:1 120:2 180:3 240:null"},
+		{"zero single", single, 0, 300, FillZero, "0:0 60:0 120:5 180:0 240:0"},
+		{"null single", single, 0, 300, FillNull, "0:null 60:null 120:5 180:null 240:null"},
+		{"previous single", single, 0, 300, FillPrevious, "0:null 60:null 120:5 180:5 240:5"},
+		{"linear single", single, 0, 300, FillLinear, "0:null 60:null 120:5 180:null 240:null"},
+		{"linear uneven", points(0, 0, 180, 6), 0, 240, FillLinear, "0:0 60:2 120:4 180:6"},
+		{"unaligned window", gaps, 30, 230, FillPrevious, "0:null 60:1 120:1 180:3"},
+		{"unaligned end", gaps, 60, 240.5, FillZero, "60:1 120:0 180:3 240:0"},
+		{"empty series", nil, 0, 180, FillLinear, "0:null 60:null 120:null"},
+		{"empty window", gaps, 120, 120, FillZero, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Unix(0, int64(tc.start*float64(time.Second)))
+			end := time.Unix(0, int64(tc.end*float64(time.Second)))
+			var got []string
+			for _, p := range FillSeries(tc.points, start, end, time.Minute, tc.policy) {
+				if p.Null {
+					got = append(got, fmt.Sprintf("%d:null", p.Timestamp))
+				} else {
+					got = append(got, fmt.Sprintf("%d:%g", p.Timestamp, p.Value))
+				}
+			}
+			if s := strings.Join(got, " "); s != tc.want {
+				t.Errorf("got %q, want %q", s, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const goldenDir = "testdata/golden"
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files with the current SQL")
+
+// goldenCase is one combination of the query layer whose SQL is pinned in <name>.sql.
+type goldenCase struct {
+	name string
+	run  func(db *gorm.DB) error
+}
+
+var (
+	goldenStart   = time.Date(2024, 3, 25, 10, 40, 16, 0, time.UTC)
+	goldenEnd     = time.Date(2024, 3, 25, 11, 20, 16, 0, time.UTC)
+	goldenAccount = "query_tae_table"
+	goldenID      = "018eb819-4048-7e69-aaa6-feb99965eb97"
+)
+
+// postHistory sends req to path of the query history API over db, any of ok is a success.
+func postHistory(db *gorm.DB, cfg QueryHistoryConfig, path string, req any, ok ...int) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set(accountHeader, goldenAccount)
+	w := httptest.NewRecorder()
+	NewQueryHistoryAPI(db, cfg).Handler().ServeHTTP(w, r)
+	for _, code := range ok {
+		if w.Code == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %d %s", path, w.Code, w.Body)
+}
+
+func goldenList(cfg QueryHistoryConfig, edit func(req *ListQueryHistoryRequest)) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		req := ListQueryHistoryRequest{StartTime: goldenStart, EndTime: goldenEnd}
+		if edit != nil {
+			edit(&req)
+		}
+		return postHistory(db, cfg, "/api/v1/query_history/list", &req, http.StatusOK)
+	}
+}
+
+func goldenDescribe(cfg QueryHistoryConfig, edit func(req *DescribeQueryHistoryRequest)) func(db *gorm.DB) error {
+	return func(db *gorm.DB) error {
+		req := DescribeQueryHistoryRequest{StatementID: goldenID}
+		if edit != nil {
+			edit(&req)
+		}
+		// no row comes back from the capture
+		return postHistory(db, cfg, "/api/v1/query_history/describe", &req, http.StatusOK, http.StatusNotFound)
+	}
+}
+
+func goldenCases() []goldenCase {
+	statsCU := DefaultQueryHistoryConfig()
+	statsCU.EnableStatsCU = true
+	statementCU := DefaultQueryHistoryConfig()
+	statementCU.EnableStatementCU = true
+	statementCU.ResponseAtExtension = 10 * time.Minute
+	withCU := func(minCU *uint) func(req *ListQueryHistoryRequest) {
+		return func(req *ListQueryHistoryRequest) {
+			req.CU, req.MinCU = true, minCU
+		}
+	}
+	zero, five := uint(0), uint(5)
+	window := func(req *DescribeQueryHistoryRequest) {
+		req.StartTime, req.EndTime = &goldenStart, &goldenEnd
+	}
+
+	return []goldenCase{
+		{"list", goldenList(DefaultQueryHistoryConfig(), nil)},
+		{"list_filters", goldenList(DefaultQueryHistoryConfig(), func(req *ListQueryHistoryRequest) {
+			req.User, req.Database, req.Status, req.Keyword = "dump", "test", failedStatus, "user_info"
+			req.SortBy, req.Asc, req.PageNumber, req.PageSize = "duration", true, 3, 50
+		})},
+		{"list_stats_cu", goldenList(statsCU, withCU(nil))},
+		{"list_stats_cu_min_cu_zero", goldenList(statsCU, withCU(&zero))},
+		{"list_stats_cu_min_cu", goldenList(statsCU, withCU(&five))},
+		{"list_stats_cu_sort_cu", goldenList(statsCU, func(req *ListQueryHistoryRequest) {
+			req.CU, req.SortBy = true, "cu"
+		})},
+		{"list_statement_cu", goldenList(statementCU, withCU(nil))},
+		{"list_statement_cu_min_cu", goldenList(statementCU, withCU(&five))},
+		{"list_statement_cu_filters", goldenList(statementCU, func(req *ListQueryHistoryRequest) {
+			withCU(&five)(req)
+			req.User, req.Status = "dump", failedStatus
+		})},
+		{"describe", goldenDescribe(DefaultQueryHistoryConfig(), nil)},
+		{"describe_window", goldenDescribe(DefaultQueryHistoryConfig(), window)},
+		{"describe_stats_cu", goldenDescribe(statsCU, func(req *DescribeQueryHistoryRequest) {
+			window(req)
+			req.CU = true
+		})},
+		{"describe_statement_cu", goldenDescribe(statementCU, func(req *DescribeQueryHistoryRequest) {
+			window(req)
+			req.CU = true
+		})},
+		{"describe_statement_cu_start_only", goldenDescribe(statementCU, func(req *DescribeQueryHistoryRequest) {
+			req.StartTime, req.CU = &goldenStart, true
+		})},
+	}
+}
+
+// TestGoldenSQL captures the SQL of every golden case and compares it with the files in
+// testdata/golden, go test -run GoldenSQL -update rewrites them.
+func TestGoldenSQL(t *testing.T) {
+	capture, err := NewSQLCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateGolden {
+		if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range goldenCases() {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(capture.DB()); err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			for _, stmt := range capture.Take() {
+				got.WriteString(stmt.String() + "\n")
+			}
+
+			path := filepath.Join(goldenDir, c.name+".sql")
+			if *updateGolden {
+				if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run with -update to create it", err)
+			}
+			if !bytes.Equal(want, got.Bytes()) {
+				t.Errorf("%s differs, run with -update to accept the new SQL\n%s", path, diffLines(string(want), got.String()))
+			}
+		})
+	}
+}
+
+// diffLines prints the lines of want and got that differ, after their common prefix and suffix.
+func diffLines(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "@@ line %d\n", prefix+1)
+	for _, l := range a[prefix : len(a)-suffix] {
+		out.WriteString("-" + l + "\n")
+	}
+	for _, l := range b[prefix : len(b)-suffix] {
+		out.WriteString("+" + l + "\n")
+	}
+	return out.String()
+}
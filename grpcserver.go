@@ -0,0 +1,354 @@
+package main
+
+//go:generate protoc -I pb --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative gorm_demo.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+
+	"github.com/xzxiong/gorm_demo/pb"
+)
+
+const (
+	// accountMetadata is the gRPC counterpart of accountHeader.
+	accountMetadata = "x-account"
+	// exportChunkBytes is the size of the data of one ExportChunk.
+	exportChunkBytes = 64 << 10
+	// maxExportChunkSize caps the rows per query a client may ask the export for.
+	maxExportChunkSize = 10 * defaultExportChunkSize
+)
+
+// GRPCServer serves pb.QueryHistory with the same query layer and limits as QueryHistoryAPI.
+type GRPCServer struct {
+	pb.UnimplementedQueryHistoryServer
+	db  *gorm.DB
+	cfg QueryHistoryConfig
+}
+
+func NewGRPCServer(db *gorm.DB, cfg QueryHistoryConfig) *GRPCServer {
+	return &GRPCServer{db: db, cfg: cfg}
+}
+
+// Register creates a grpc.Server serving s.
+func (s *GRPCServer) Register(opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(opts...)
+	pb.RegisterQueryHistoryServer(srv, s)
+	return srv
+}
+
+// grpcError maps the errors of the query layer to status codes.
+func grpcError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrInvalidParameter):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// account authenticates the caller like QueryHistoryAPI, from the authorization and
+// x-account metadata.
+func (s *GRPCServer) account(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	return s.cfg.callerAccount(first("authorization"), first(accountMetadata), accountMetadata+" metadata")
+}
+
+func timeFromPB(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
+
+func timePtrFromPB(t *timestamppb.Timestamp) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := t.AsTime()
+	return &v
+}
+
+func timeToPB(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func statementToPB(s *StatementInfo) *pb.Statement {
+	out := &pb.Statement{
+		StatementId:          s.StatementId,
+		TransactionId:        s.TransactionId,
+		SessionId:            s.SessionId,
+		Account:              s.Account,
+		User:                 s.User,
+		Host:                 s.Host,
+		Database:             s.Database,
+		Statement:            s.Statement,
+		StatementTag:         s.StatementTag,
+		StatementFingerprint: s.StatementFingerprint,
+		NodeUuid:             s.NodeUuid,
+		NodeType:             s.NodeType,
+		RequestAt:            timeToPB(s.RequestAt),
+		ResponseAt:           timeToPB(s.ResponseAt),
+		Duration:             s.Duration,
+		Status:               s.Status,
+		ErrorCode:            s.ErrCode,
+		Error:                s.Error,
+		StatementType:        s.StatementType,
+		QueryType:            s.QueryType,
+		SqlSourceType:        s.SqlSourceType,
+		ResultCount:          s.ResultCount,
+		Cu:                   s.CU,
+	}
+	if s.RowsRead != nil {
+		out.RowsRead = *s.RowsRead
+	}
+	if s.BytesScan != nil {
+		out.BytesScan = *s.BytesScan
+	}
+	return out
+}
+
+func (s *GRPCServer) ListStatements(ctx context.Context, in *pb.ListStatementsRequest) (*pb.ListStatementsResponse, error) {
+	account, err := s.account(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	req := ListQueryHistoryRequest{
+		StartTime:  timeFromPB(in.StartTime),
+		EndTime:    timeFromPB(in.EndTime),
+		User:       in.User,
+		Database:   in.Database,
+		Status:     in.Status,
+		Keyword:    in.Keyword,
+		CU:         in.Cu,
+		SortBy:     in.SortBy,
+		Asc:        in.Asc,
+		PageNumber: uint(in.PageNumber),
+		PageSize:   uint(in.PageSize),
+	}
+	if in.MinCu != nil {
+		minCU := uint(*in.MinCu)
+		req.MinCU = &minCU
+	}
+	if err := req.validate(&s.cfg); err != nil {
+		return nil, grpcError(err)
+	}
+
+	cond, args, order, limit, offset := generateFilters(&req, account)
+	joinCond, joinArgs := generateJoinFilters(&req, account, s.cfg.ResponseAtExtension)
+	proj, cu := generateProjection(req.CU, s.cfg.EnableStatementCU, s.cfg.EnableStatsCU)
+	si := StatementInfo{Account: account}
+	records, _, err := si.SelectStatements(s.db.WithContext(ctx), proj, cond, args, order,
+		limit, offset, NonUserRawComment, cu, req.MinCU, joinCond, joinArgs, s.cfg.EnableStatementCU)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.ListStatementsResponse{
+		PageNumber: uint32(req.PageNumber),
+		PageSize:   uint32(req.PageSize),
+		Statements: make([]*pb.Statement, 0, len(records)),
+	}
+	for i := range records {
+		resp.Statements = append(resp.Statements, statementToPB(&records[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) DescribeStatement(ctx context.Context, in *pb.DescribeStatementRequest) (*pb.Statement, error) {
+	account, err := s.account(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	req := DescribeQueryHistoryRequest{
+		StatementID: in.StatementId,
+		StartTime:   timePtrFromPB(in.StartTime),
+		EndTime:     timePtrFromPB(in.EndTime),
+		CU:          in.Cu,
+	}
+	if err := req.validate(&s.cfg); err != nil {
+		return nil, grpcError(err)
+	}
+
+	var responseEnd *time.Time
+	if req.EndTime != nil {
+		end := req.EndTime.Add(s.cfg.ResponseAtExtension)
+		responseEnd = &end
+	}
+	proj, cu := generateProjection(req.CU, s.cfg.EnableStatementCU, s.cfg.EnableStatsCU)
+	si := StatementInfo{StatementId: req.StatementID, Account: account}
+	record, err := si.SelectByStatementId(s.db.WithContext(ctx), &proj, req.StartTime, req.EndTime,
+		NonUserRawComment, cu, responseEnd, s.cfg.EnableStatementCU)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return statementToPB(record), nil
+}
+
+// chunkStreamWriter buffers the export and sends it in chunks of exportChunkBytes.
+type chunkStreamWriter struct {
+	stream pb.QueryHistory_ExportStatementsServer
+	buf    []byte
+	rows   int64
+}
+
+func (w *chunkStreamWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= exportChunkBytes {
+		if err := w.send(exportChunkBytes); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *chunkStreamWriter) send(n int) error {
+	chunk := &pb.ExportChunk{Data: append([]byte(nil), w.buf[:n]...), Rows: w.rows}
+	w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	return w.stream.Send(chunk)
+}
+
+// flush sends the rest of the export, even empty so the last chunk has the total rows.
+func (w *chunkStreamWriter) flush() error {
+	return w.send(len(w.buf))
+}
+
+func (s *GRPCServer) ExportStatements(in *pb.ExportStatementsRequest, stream pb.QueryHistory_ExportStatementsServer) error {
+	ctx := stream.Context()
+	account, err := s.account(ctx)
+	if err != nil {
+		return grpcError(err)
+	}
+	start, end := timeFromPB(in.StartTime), timeFromPB(in.EndTime)
+	if err := validateTimeWindow(start, end, s.cfg.MaxTimeWindow); err != nil {
+		return grpcError(err)
+	}
+	format := ExportFormat(in.Format)
+	if format == "" {
+		format = ExportJSONL
+	}
+
+	w := &chunkStreamWriter{stream: stream}
+	rows, err := ExportStatements(ctx, s.db.WithContext(ctx), w, ExportOptions{
+		Account:     account,
+		Start:       start,
+		End:         end,
+		Format:      format,
+		Compression: ExportCompression(in.Compression),
+		ChunkSize:   int(min(in.ChunkSize, maxExportChunkSize)),
+		SQLComment:  NonUserRawComment,
+		Progress:    func(p ExportProgress) { w.rows = p.Rows },
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	w.rows = rows
+	return grpcError(w.flush())
+}
+
+func (s *GRPCServer) QueryMetricRange(ctx context.Context, in *pb.MetricRangeRequest) (*pb.MetricRangeResponse, error) {
+	account, err := s.account(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	// system_metrics is shared by the whole cluster
+	if account != sysAccount {
+		return nil, grpcError(fmt.Errorf("%w: metrics are only readable by %s", ErrPermissionDenied, sysAccount))
+	}
+	start, end := timeFromPB(in.StartTime), timeFromPB(in.EndTime)
+	var step time.Duration
+	if in.Step != nil {
+		step = in.Step.AsDuration()
+	}
+	db := s.db.WithContext(ctx)
+
+	var series []MetricSeries
+	if in.Promql != "" {
+		series, err = QueryPromQLRange(db, PromQuery{
+			Query: in.Promql, Start: start, End: end, Step: step, CumulativeCounters: in.CumulativeCounters,
+		}, NonUserRawComment)
+	} else {
+		q := MetricQuery{
+			Table:     in.Table,
+			Start:     start,
+			End:       end,
+			Step:      step,
+			SeriesBy:  in.SeriesBy,
+			SeriesAgg: in.SeriesAgg,
+			GroupBy:   in.GroupBy,
+			Agg:       in.Agg,
+			PerSecond: in.PerSecond,
+			Fill:      FillPolicy(in.Fill),
+		}
+		for _, m := range in.Matchers {
+			q.Matchers = append(q.Matchers, LabelMatcher{Name: m.Name, Op: m.Op, Value: m.Value})
+		}
+		series, err = QueryMetrics(db, q, NonUserRawComment)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &pb.MetricRangeResponse{Series: make([]*pb.MetricSeries, 0, len(series))}
+	for _, ms := range series {
+		out := &pb.MetricSeries{Labels: ms.Labels, Points: make([]*pb.MetricPoint, 0, len(ms.Points))}
+		for _, p := range ms.Points {
+			out.Points = append(out.Points, &pb.MetricPoint{Timestamp: p.Timestamp, Value: p.Value, Null: p.Null})
+		}
+		resp.Series = append(resp.Series, out)
+	}
+	return resp, nil
+}
+
+// ServeGRPC serves s on addr until ctx is done.
+func ServeGRPC(ctx context.Context, addr string, s *GRPCServer) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := s.Register()
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	return srv.Serve(lis)
+}
+
+// WithBearerToken sets the token of the outgoing calls of a client, see AccountTokens.
+func WithBearerToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// WithAccount sets the account of the outgoing calls of a client.
+func WithAccount(ctx context.Context, account string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, accountMetadata, account)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/xzxiong/gorm_demo/pb"
+)
+
+const bufconnSize = 1 << 20
+
+// NewBufconnClient serves s over an in-memory listener and returns a client of it,
+// the server and the client are stopped with the test.
+func NewBufconnClient(t testing.TB, s *GRPCServer) pb.QueryHistoryClient {
+	t.Helper()
+	lis := bufconn.Listen(bufconnSize)
+	srv := s.Register()
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewQueryHistoryClient(conn)
+}
+
+func fixtureClient(t *testing.T) pb.QueryHistoryClient {
+	f := StartFakeMO(t, FakeMOOptions{Fixtures: []string{fakeMOFixtures}})
+	cfg := DefaultQueryHistoryConfig()
+	cfg.EnableStatementCU = true
+	return NewBufconnClient(t, NewGRPCServer(f.DB(), cfg))
+}
+
+func wantCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != code {
+		t.Fatalf("got %v (%v), want %v", got, err, code)
+	}
+}
+
+func TestGRPCListStatements(t *testing.T) {
+	client := fixtureClient(t)
+	ctx := WithAccount(context.Background(), sysAccount)
+	window := &pb.ListStatementsRequest{
+		StartTime: timestamppb.New(fixtureStart),
+		EndTime:   timestamppb.New(fixtureEnd),
+	}
+
+	resp, err := client.ListStatements(ctx, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, s := range resp.Statements {
+		ids = append(ids, s.StatementId)
+	}
+	if got, want := strings.Join(ids, ","), fixtureSuccessID+","+fixtureFailedID; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	_, err = client.ListStatements(context.Background(), window)
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = client.ListStatements(ctx, &pb.ListStatementsRequest{
+		StartTime: timestamppb.New(fixtureEnd),
+		EndTime:   timestamppb.New(fixtureStart),
+	})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func TestGRPCDescribeStatement(t *testing.T) {
+	client := fixtureClient(t)
+	ctx := WithAccount(context.Background(), sysAccount)
+
+	s, err := client.DescribeStatement(ctx, &pb.DescribeStatementRequest{
+		StatementId: fixtureSuccessID,
+		StartTime:   timestamppb.New(fixtureStart),
+		EndTime:     timestamppb.New(fixtureEnd),
+		Cu:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Statement != "select * from test.user_info;" || s.Cu == nil || *s.Cu != 0.0125 {
+		t.Errorf("got %q cu %v", s.Statement, s.Cu)
+	}
+
+	_, err = client.DescribeStatement(WithAccount(context.Background(), "acc1"),
+		&pb.DescribeStatementRequest{StatementId: fixtureSuccessID})
+	wantCode(t, err, codes.NotFound)
+	_, err = client.DescribeStatement(ctx, &pb.DescribeStatementRequest{StatementId: "1; drop table x"})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func exportAll(ctx context.Context, client pb.QueryHistoryClient, in *pb.ExportStatementsRequest) ([]byte, int64, error) {
+	stream, err := client.ExportStatements(ctx, in)
+	if err != nil {
+		return nil, 0, err
+	}
+	var (
+		data bytes.Buffer
+		rows int64
+	)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return data.Bytes(), rows, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		data.Write(chunk.Data)
+		rows = chunk.Rows
+	}
+}
+
+func TestGRPCExportStatements(t *testing.T) {
+	client := fixtureClient(t)
+	ctx := WithAccount(context.Background(), sysAccount)
+
+	// a chunk of one row walks the window with the keyset
+	data, rows, err := exportAll(ctx, client, &pb.ExportStatementsRequest{
+		StartTime: timestamppb.New(fixtureStart),
+		EndTime:   timestamppb.New(fixtureEnd),
+		ChunkSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r struct {
+			StatementId string `json:"statement_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		ids = append(ids, r.StatementId)
+	}
+	if got, want := strings.Join(ids, ","), fixtureFailedID+","+fixtureSuccessID; rows != 2 || got != want {
+		t.Errorf("got %d rows %q, want 2 rows %q", rows, got, want)
+	}
+
+	_, _, err = exportAll(WithAccount(context.Background(), ""), client, &pb.ExportStatementsRequest{
+		StartTime: timestamppb.New(fixtureStart),
+		EndTime:   timestamppb.New(fixtureEnd),
+	})
+	wantCode(t, err, codes.PermissionDenied)
+}
+
+func TestGRPCExportChunkSize(t *testing.T) {
+	capture, err := NewSQLCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewBufconnClient(t, NewGRPCServer(capture.DB(), DefaultQueryHistoryConfig()))
+	if _, _, err := exportAll(WithAccount(context.Background(), sysAccount), client, &pb.ExportStatementsRequest{
+		StartTime: timestamppb.New(fixtureStart),
+		EndTime:   timestamppb.New(fixtureEnd),
+		ChunkSize: 1 << 31,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stmts := capture.Take()
+	if len(stmts) != 1 || fmt.Sprint(stmts[0].Args[len(stmts[0].Args)-1]) != fmt.Sprint(maxExportChunkSize) {
+		t.Fatalf("got %v, want one query with limit %d", stmts, maxExportChunkSize)
+	}
+}
+
+func TestGRPCQueryMetricRange(t *testing.T) {
+	f := StartFakeMO(t, FakeMOOptions{})
+	if err := f.CreateMetricTable("test_metric", "node"); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []float64{1, 2, 3, 4} {
+		if err := f.Insert(metricsDatabase+".test_metric", map[string]any{
+			metricTimeCol: fixtureStart.Add(time.Duration(i) * 30 * time.Second), metricValueCol: v, "node": "cn1",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := NewBufconnClient(t, NewGRPCServer(f.DB(), DefaultQueryHistoryConfig()))
+	ctx := WithAccount(context.Background(), sysAccount)
+	query := &pb.MetricRangeRequest{
+		Table:     "test_metric",
+		StartTime: timestamppb.New(fixtureStart),
+		EndTime:   timestamppb.New(fixtureStart.Add(2 * time.Minute)),
+		Step:      durationpb.New(time.Minute),
+		GroupBy:   []string{"node"},
+		Agg:       "sum",
+		SeriesAgg: "sum",
+	}
+
+	resp, err := client.QueryMetricRange(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Series) != 1 || resp.Series[0].Labels["node"] != "cn1" {
+		t.Fatalf("got %v, want one series of cn1", resp.Series)
+	}
+	var values []float64
+	for _, p := range resp.Series[0].Points {
+		values = append(values, p.Value)
+	}
+	if fmt.Sprint(values) != "[3 7]" {
+		t.Errorf("got %v, want [3 7]", values)
+	}
+
+	_, err = client.QueryMetricRange(WithAccount(context.Background(), "acc1"), query)
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = client.QueryMetricRange(ctx, &pb.MetricRangeRequest{Promql: "rate(test_metric"})
+	wantCode(t, err, codes.InvalidArgument)
+	_, err = client.QueryMetricRange(ctx, &pb.MetricRangeRequest{Table: "test_metric; drop"})
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func TestGRPCTokens(t *testing.T) {
+	f := StartFakeMO(t, FakeMOOptions{Fixtures: []string{fakeMOFixtures}})
+	cfg := DefaultQueryHistoryConfig()
+	cfg.AccountTokens = map[string]string{"t-sys": sysAccount, "t-acc1": "acc1"}
+	client := NewBufconnClient(t, NewGRPCServer(f.DB(), cfg))
+	window := &pb.ListStatementsRequest{
+		StartTime: timestamppb.New(fixtureStart),
+		EndTime:   timestamppb.New(fixtureEnd),
+	}
+
+	_, err := client.ListStatements(WithAccount(context.Background(), sysAccount), window)
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = client.ListStatements(WithBearerToken(context.Background(), "t-x"), window)
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = client.ListStatements(WithAccount(WithBearerToken(context.Background(), "t-acc1"), sysAccount), window)
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = client.QueryMetricRange(WithBearerToken(context.Background(), "t-acc1"), &pb.MetricRangeRequest{Table: "test_metric"})
+	wantCode(t, err, codes.PermissionDenied)
+
+	resp, err := client.ListStatements(WithBearerToken(context.Background(), "t-sys"), window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Statements) != 2 {
+		t.Errorf("got %d statements, want 2", len(resp.Statements))
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// histogramLeLabel is the label holding the upper bound of a histogram bucket.
+const histogramLeLabel = "le"
+
+type HistogramBucket struct {
+	UpperBound float64
+	// Count is cumulative, the observations less than or equal to UpperBound.
+	Count float64
+}
+
+// HistogramQuantile estimates the q-quantile from cumulative buckets like
+// Prometheus histogram_quantile: linear interpolation inside the bucket holding
+// the rank, the lower bound of the first bucket being 0 when its upper bound is
+// positive, and the upper bound of the highest finite bucket when the rank falls
+// into the +Inf bucket. A q below 0 is -Inf and above 1 is +Inf. It returns NaN
+// and false for a NaN q, when there is no +Inf bucket or no observation.
+func HistogramQuantile(q float64, buckets []HistogramBucket) (float64, bool) {
+	switch {
+	case math.IsNaN(q):
+		return math.NaN(), false
+	case q < 0:
+		return math.Inf(-1), true
+	case q > 1:
+		return math.Inf(1), true
+	}
+	if len(buckets) < 2 {
+		return math.NaN(), false
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].UpperBound < buckets[j].UpperBound })
+	if !math.IsInf(buckets[len(buckets)-1].UpperBound, 1) {
+		return math.NaN(), false
+	}
+	// counts of increase() can be slightly non monotonic, Prometheus fixes that the same way
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].Count < buckets[i-1].Count {
+			buckets[i].Count = buckets[i-1].Count
+		}
+	}
+	total := buckets[len(buckets)-1].Count
+	if total == 0 {
+		return math.NaN(), false
+	}
+
+	rank := q * total
+	b := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].Count >= rank })
+	if b == len(buckets)-1 {
+		return buckets[len(buckets)-2].UpperBound, true
+	}
+	if b == 0 && buckets[0].UpperBound <= 0 {
+		return buckets[0].UpperBound, true
+	}
+
+	var lower, countBefore float64
+	if b > 0 {
+		lower = buckets[b-1].UpperBound
+		countBefore = buckets[b-1].Count
+	}
+	upper := buckets[b].UpperBound
+	inBucket := buckets[b].Count - countBefore
+	if inBucket == 0 {
+		return upper, true
+	}
+	return lower + (upper-lower)*(rank-countBefore)/inBucket, true
+}
+
+// HistogramQuantileQuery computes quantiles over time from a bucket table of system_metrics,
+// the table must have an le column.
+type HistogramQuantileQuery struct {
+	// Table is the bucket table of the histogram.
+	Table     string
+	Quantiles []float64
+	Start     time.Time
+	End       time.Time
+	Step      time.Duration
+	// Range is the window of the bucket increase per point, default Step.
+	Range    time.Duration
+	Matchers []LabelMatcher
+	// GroupBy keeps one quantile series per distinct labels, le is implied.
+	GroupBy            []string
+	CumulativeCounters bool
+}
+
+type QuantileSeries struct {
+	Quantile float64           `json:"quantile"`
+	Labels   map[string]string `json:"labels"`
+	Points   []MetricPoint     `json:"points"`
+}
+
+// QueryHistogramQuantiles evaluates histogram_quantile(q, sum by (le, GroupBy) (rate(Table[Range])))
+// at every step for each quantile. The rates are computed in SQL, the quantiles here.
+func QueryHistogramQuantiles(db *gorm.DB, q HistogramQuantileQuery, sqlComment string) ([]QuantileSeries, error) {
+	if len(q.Quantiles) == 0 {
+		return nil, errors.New("no quantile")
+	}
+	for _, quantile := range q.Quantiles {
+		if quantile < 0 || quantile > 1 || math.IsNaN(quantile) {
+			return nil, fmt.Errorf("invalid quantile: %v", quantile)
+		}
+	}
+	for _, l := range q.GroupBy {
+		if l == histogramLeLabel {
+			return nil, errors.New("le can not be grouped by")
+		}
+	}
+	if q.Range == 0 {
+		q.Range = q.Step
+	}
+	expr := &promAggregate{
+		Op: "sum",
+		By: append(append([]string{}, q.GroupBy...), histogramLeLabel),
+		Expr: &promCall{
+			Func: "rate",
+			Arg:  &promSelector{Metric: q.Table, Matchers: q.Matchers, Range: q.Range},
+		},
+	}
+	pq := PromQuery{Start: q.Start, End: q.End, Step: q.Step, CumulativeCounters: q.CumulativeCounters}
+	bucketSeries, err := pq.runExpr(db, sqlComment, false, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	// collect the buckets of every group and timestamp
+	type groupBuckets struct {
+		labels  map[string]string
+		buckets map[int64][]HistogramBucket
+	}
+	groups := make(map[string]*groupBuckets)
+	var keys []string
+	for _, s := range bucketSeries {
+		le, err := strconv.ParseFloat(s.Labels[histogramLeLabel], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid le %q: %v", s.Labels[histogramLeLabel], err)
+		}
+		labels := make(map[string]string, len(q.GroupBy))
+		var key strings.Builder
+		for _, l := range q.GroupBy {
+			labels[l] = s.Labels[l]
+			key.WriteString(s.Labels[l])
+			key.WriteByte(0)
+		}
+		g, ok := groups[key.String()]
+		if !ok {
+			g = &groupBuckets{labels: labels, buckets: make(map[int64][]HistogramBucket)}
+			groups[key.String()] = g
+			keys = append(keys, key.String())
+		}
+		for _, p := range s.Points {
+			if !p.Null {
+				g.buckets[p.Timestamp] = append(g.buckets[p.Timestamp], HistogramBucket{UpperBound: le, Count: p.Value})
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	result := make([]QuantileSeries, 0, len(q.Quantiles)*len(groups))
+	for _, quantile := range q.Quantiles {
+		for _, key := range keys {
+			g := groups[key]
+			timestamps := make([]int64, 0, len(g.buckets))
+			for ts := range g.buckets {
+				timestamps = append(timestamps, ts)
+			}
+			sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+			series := QuantileSeries{Quantile: quantile, Labels: g.labels, Points: make([]MetricPoint, 0, len(timestamps))}
+			for _, ts := range timestamps {
+				buckets := append([]HistogramBucket{}, g.buckets[ts]...)
+				v, ok := HistogramQuantile(quantile, buckets)
+				series.Points = append(series.Points, MetricPoint{Timestamp: ts, Value: v, Null: !ok})
+			}
+			result = append(result, series)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	inf := math.Inf(1)
+	buckets := func(kv ...float64) []HistogramBucket {
+		var bs []HistogramBucket
+		for i := 0; i < len(kv); i += 2 {
+			bs = append(bs, HistogramBucket{UpperBound: kv[i], Count: kv[i+1]})
+		}
+		return bs
+	}
+	latency := func() []HistogramBucket { return buckets(0.1, 10, 0.5, 30, 1, 40, inf, 50) }
+	for _, tc := range []struct {
+		name    string
+		q       float64
+		buckets []HistogramBucket
+		want    float64
+		ok      bool
+	}{
+		{"first bucket from 0", 0.1, latency(), 0.05, true},
+		{"inside a bucket", 0.5, latency(), 0.4, true},
+		{"upper bound of a bucket", 0.8, latency(), 1, true},
+		{"zero", 0, latency(), 0, true},
+		{"in +Inf", 0.9, latency(), 1, true},
+		{"one in +Inf", 1, latency(), 1, true},
+		{"below 0", -0.1, latency(), math.Inf(-1), true},
+		{"above 1", 1.1, latency(), inf, true},
+		{"nan", math.NaN(), latency(), math.NaN(), false},
+		{"unsorted", 0.5, buckets(inf, 50, 1, 40, 0.1, 10, 0.5, 30), 0.4, true},
+		{"missing +Inf", 0.5, buckets(0.1, 10, 1, 20), math.NaN(), false},
+		{"only +Inf", 0.5, buckets(inf, 10), math.NaN(), false},
+		{"no observation", 0.5, buckets(0.1, 0, 1, 0, inf, 0), math.NaN(), false},
+		{"non monotonic at a bound", 0.5, buckets(0.1, 10, 0.5, 8, 1, 20, inf, 20), 0.1, true},
+		{"non monotonic inside", 0.75, buckets(0.1, 10, 0.5, 8, 1, 20, inf, 20), 0.75, true},
+		{"negative first bucket", 0.25, buckets(-1, 5, 0, 10, inf, 10), -1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := HistogramQuantile(tc.q, tc.buckets)
+			same := got == tc.want || math.Abs(got-tc.want) < 1e-9 || (math.IsNaN(got) && math.IsNaN(tc.want))
+			if !same || ok != tc.ok {
+				t.Errorf("got %v %v, want %v %v", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// accountHeader carries the account of the caller, set by the gateway in front of the service.
+const accountHeader = "X-Account"
+
+var (
+	ErrInvalidParameter = errors.New("invalid parameter")
+	// ErrUnauthenticated is a caller without a valid token when the service has AccountTokens.
+	ErrUnauthenticated = errors.New("unauthenticated")
+)
+
+// historySortCols maps the sort keys of ListQueryHistoryRequest to columns of the result.
+var historySortCols = map[string]string{
+	"request_at":   "request_at",
+	"duration":     "duration",
+	"rows_read":    "rows_read",
+	"bytes_scan":   "bytes_scan",
+	"result_count": "result_count",
+	"cu":           "cu",
+}
+
+// QueryHistoryConfig holds the limits and feature flags of the query-history service.
+type QueryHistoryConfig struct {
+	// EnableStatementCU reads the CU from mo_catalog.statement_cu instead of calculating it.
+	EnableStatementCU bool `json:"enableStatementCU" yaml:"enableStatementCU"`
+	// EnableStatsCU calculates the CU from the stats column when statement_cu is not enabled.
+	EnableStatsCU bool `json:"enableStatsCU" yaml:"enableStatsCU"`
+	// ResponseAtExtension widens the response_at range of statement_cu past the end of the
+	// request, a statement requested in the window can respond after it.
+	ResponseAtExtension time.Duration `json:"responseAtExtension" yaml:"responseAtExtension"`
+	MaxTimeWindow       time.Duration `json:"maxTimeWindow" yaml:"maxTimeWindow"`
+	DefaultPageSize     uint          `json:"defaultPageSize" yaml:"defaultPageSize"`
+	MaxPageSize         uint          `json:"maxPageSize" yaml:"maxPageSize"`
+	// MaxMinCU bounds the min_cu filter, 0 means no bound.
+	MaxMinCU uint `json:"maxMinCU" yaml:"maxMinCU"`
+	// AccountTokens maps the bearer tokens of the callers to their accounts. Without tokens
+	// the declared account, the X-Account header or x-account metadata, is trusted as is:
+	// the service must then only be reachable through a gateway that sets it.
+	AccountTokens map[string]string `json:"accountTokens" yaml:"accountTokens"`
+}
+
+func DefaultQueryHistoryConfig() QueryHistoryConfig {
+	return QueryHistoryConfig{
+		EnableStatsCU:       true,
+		ResponseAtExtension: 5 * time.Minute,
+		MaxTimeWindow:       7 * 24 * time.Hour,
+		DefaultPageSize:     20,
+		MaxPageSize:         100,
+	}
+}
+
+func (c *QueryHistoryConfig) cuEnabled() bool {
+	return c.EnableStatementCU || c.EnableStatsCU
+}
+
+// callerAccount authenticates a request by the Authorization value of its bearer token when
+// the service has AccountTokens, a declared account must then be the one of the token.
+// Otherwise it is the declared account, which source names in the errors.
+func (c *QueryHistoryConfig) callerAccount(authorization, declared, source string) (string, error) {
+	if len(c.AccountTokens) == 0 {
+		if declared == "" {
+			return "", fmt.Errorf("%w: missing %s", ErrPermissionDenied, source)
+		}
+		return declared, nil
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return "", fmt.Errorf("%w: missing bearer token", ErrUnauthenticated)
+	}
+	var account string
+	for t, a := range c.AccountTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			account = a
+		}
+	}
+	switch {
+	case account == "":
+		return "", fmt.Errorf("%w: unknown bearer token", ErrUnauthenticated)
+	case declared != "" && declared != account:
+		return "", fmt.Errorf("%w: %s is not the account of the token", ErrPermissionDenied, source)
+	}
+	return account, nil
+}
+
+type ListQueryHistoryRequest struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	User      string    `json:"user,omitempty"`
+	Database  string    `json:"database,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	// Keyword matches a part of the statement text.
+	Keyword string `json:"keyword,omitempty"`
+	// CU asks for the CU of every statement, MinCU implies it.
+	CU    bool  `json:"cu,omitempty"`
+	MinCU *uint `json:"min_cu,omitempty"`
+	// SortBy is one of the keys in historySortCols, default request_at.
+	SortBy string `json:"sort_by,omitempty"`
+	Asc    bool   `json:"asc,omitempty"`
+	// PageNumber starts at 1.
+	PageNumber uint `json:"page_number,omitempty"`
+	PageSize   uint `json:"page_size,omitempty"`
+}
+
+type DescribeQueryHistoryRequest struct {
+	StatementID string `json:"statement_id"`
+	// StartTime and EndTime narrow the request_at to look in, both optional.
+	StartTime *time.Time `json:"start_time,omitempty"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	CU        bool       `json:"cu,omitempty"`
+}
+
+type ListQueryHistoryResponse struct {
+	PageNumber uint            `json:"page_number"`
+	PageSize   uint            `json:"page_size"`
+	Statements []StatementInfo `json:"statements"`
+}
+
+type historyErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func validateTimeWindow(start, end time.Time, maxWindow time.Duration) error {
+	if start.IsZero() || end.IsZero() || !start.Before(end) {
+		return fmt.Errorf("%w: start_time must be before end_time", ErrInvalidParameter)
+	}
+	if maxWindow > 0 && end.Sub(start) > maxWindow {
+		return fmt.Errorf("%w: time window exceeds %s", ErrInvalidParameter, maxWindow)
+	}
+	return nil
+}
+
+func (req *ListQueryHistoryRequest) validate(cfg *QueryHistoryConfig) error {
+	if err := validateTimeWindow(req.StartTime, req.EndTime, cfg.MaxTimeWindow); err != nil {
+		return err
+	}
+	if req.PageNumber == 0 {
+		req.PageNumber = 1
+	}
+	if req.PageSize == 0 {
+		req.PageSize = cfg.DefaultPageSize
+	}
+	if cfg.MaxPageSize > 0 && req.PageSize > cfg.MaxPageSize {
+		return fmt.Errorf("%w: page_size exceeds %d", ErrInvalidParameter, cfg.MaxPageSize)
+	}
+	if req.MinCU != nil && *req.MinCU > 0 {
+		if cfg.MaxMinCU > 0 && *req.MinCU > cfg.MaxMinCU {
+			return fmt.Errorf("%w: min_cu exceeds %d", ErrInvalidParameter, cfg.MaxMinCU)
+		}
+		req.CU = true
+	}
+	if req.CU && !cfg.cuEnabled() {
+		return fmt.Errorf("%w: cu is not enabled", ErrInvalidParameter)
+	}
+	if req.SortBy == "" {
+		req.SortBy = "request_at"
+	}
+	if _, ok := historySortCols[req.SortBy]; !ok || (req.SortBy == "cu" && !req.CU) {
+		return fmt.Errorf("%w: unknown sort_by %s", ErrInvalidParameter, req.SortBy)
+	}
+	return nil
+}
+
+func (req *DescribeQueryHistoryRequest) validate(cfg *QueryHistoryConfig) error {
+	if !uuidRegexp.MatchString(req.StatementID) {
+		return fmt.Errorf("%w: invalid statement_id %q", ErrInvalidParameter, req.StatementID)
+	}
+	if req.StartTime != nil && req.EndTime != nil {
+		if err := validateTimeWindow(*req.StartTime, *req.EndTime, cfg.MaxTimeWindow); err != nil {
+			return err
+		}
+	}
+	if req.CU && !cfg.cuEnabled() {
+		return fmt.Errorf("%w: cu is not enabled", ErrInvalidParameter)
+	}
+	return nil
+}
+
+// likeEscaper makes the wildcards of a keyword match themselves. The escape is ! rather than
+// a backslash, which would need escaping again in the SQL the query layer interpolates.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// generateFilters turns the request into the arguments of SelectStatements.
+func generateFilters(req *ListQueryHistoryRequest, account string) (cond string, args []any, order string, limit, offset uint) {
+	// qualified since statement_cu is joined when the CU comes from it
+	cond = "system.statement_info.account = ? and request_at >= ? and request_at < ?"
+	args = []any{account, req.StartTime, req.EndTime}
+	if req.User != "" {
+		cond += " and `user` = ?"
+		args = append(args, req.User)
+	}
+	if req.Database != "" {
+		cond += " and `database` = ?"
+		args = append(args, req.Database)
+	}
+	if req.Status != "" {
+		cond += " and status = ?"
+		args = append(args, req.Status)
+	}
+	if req.Keyword != "" {
+		cond += " and statement like ? escape '!'"
+		args = append(args, "%"+likeEscaper.Replace(req.Keyword)+"%")
+	}
+	order = historySortCols[req.SortBy]
+	if !req.Asc {
+		order += " desc"
+	}
+	return cond, args, order, req.PageSize, (req.PageNumber - 1) * req.PageSize
+}
+
+// generateJoinFilters selects the statement_cu rows that may belong to the listed statements.
+func generateJoinFilters(req *ListQueryHistoryRequest, account string, responseAtExtension time.Duration) (string, []any) {
+	return "account = ? and response_at >= ? and response_at <= ?",
+		[]any{account, req.StartTime, req.EndTime.Add(responseAtExtension)}
+}
+
+// generateProjection adds the cu column when it is asked for, read from statement_cu
+// when enableStatementCU, else calculated from the stats.
+func generateProjection(needCU, enableStatementCU, enableStatsCU bool) (string, bool) {
+	switch {
+	case !needCU:
+		return historyProj, false
+	case enableStatementCU:
+		return historyProj + ", tmpcu.cu AS `cu`", true
+	case enableStatsCU:
+		return historyProj + historyCUProj, true
+	default:
+		return historyProj, false
+	}
+}
+
+// QueryHistoryAPI serves the list and describe endpoints over system.statement_info,
+// the account comes from the bearer token or the X-Account header, see AccountTokens, and
+// every query is tagged with NonUserComment.
+type QueryHistoryAPI struct {
+	db  *gorm.DB
+	cfg QueryHistoryConfig
+}
+
+func NewQueryHistoryAPI(db *gorm.DB, cfg QueryHistoryConfig) *QueryHistoryAPI {
+	return &QueryHistoryAPI{db: db, cfg: cfg}
+}
+
+func (a *QueryHistoryAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/query_history/list", a.list)
+	mux.HandleFunc("POST /api/v1/query_history/describe", a.describe)
+	return mux
+}
+
+func writeHistoryJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error(context.TODO(), "write response: %v", err)
+	}
+}
+
+func writeHistoryError(w http.ResponseWriter, err error) {
+	code, errCode := http.StatusInternalServerError, "InternalError"
+	switch {
+	case errors.Is(err, ErrInvalidParameter):
+		code, errCode = http.StatusBadRequest, "InvalidParameter"
+	case errors.Is(err, ErrUnauthenticated):
+		code, errCode = http.StatusUnauthorized, "Unauthenticated"
+	case errors.Is(err, ErrPermissionDenied):
+		code, errCode = http.StatusForbidden, "PermissionDenied"
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		code, errCode = http.StatusNotFound, "NotFound"
+	case errors.Is(err, context.DeadlineExceeded):
+		code, errCode = http.StatusGatewayTimeout, "Timeout"
+	}
+	if code == http.StatusInternalServerError {
+		logger.Error(context.TODO(), "query history: %v", err)
+	}
+	writeHistoryJSON(w, code, historyErrorResponse{Code: errCode, Message: err.Error()})
+}
+
+// decodeHistoryRequest reads the account and the json body.
+func (a *QueryHistoryAPI) decodeHistoryRequest(r *http.Request, req any) (string, error) {
+	account, err := a.cfg.callerAccount(r.Header.Get("Authorization"), r.Header.Get(accountHeader), accountHeader+" header")
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidParameter, err)
+	}
+	return account, nil
+}
+
+func (a *QueryHistoryAPI) list(w http.ResponseWriter, r *http.Request) {
+	var req ListQueryHistoryRequest
+	account, err := a.decodeHistoryRequest(r, &req)
+	if err == nil {
+		err = req.validate(&a.cfg)
+	}
+	if err != nil {
+		writeHistoryError(w, err)
+		return
+	}
+
+	cond, args, order, limit, offset := generateFilters(&req, account)
+	joinCond, joinArgs := generateJoinFilters(&req, account, a.cfg.ResponseAtExtension)
+	proj, cu := generateProjection(req.CU, a.cfg.EnableStatementCU, a.cfg.EnableStatsCU)
+	si := StatementInfo{Account: account}
+	records, _, err := si.SelectStatements(a.db.WithContext(r.Context()), proj, cond, args, order,
+		limit, offset, NonUserRawComment, cu, req.MinCU, joinCond, joinArgs, a.cfg.EnableStatementCU)
+	if err != nil {
+		writeHistoryError(w, err)
+		return
+	}
+	writeHistoryJSON(w, http.StatusOK, ListQueryHistoryResponse{
+		PageNumber: req.PageNumber,
+		PageSize:   req.PageSize,
+		Statements: records,
+	})
+}
+
+func (a *QueryHistoryAPI) describe(w http.ResponseWriter, r *http.Request) {
+	var req DescribeQueryHistoryRequest
+	account, err := a.decodeHistoryRequest(r, &req)
+	if err == nil {
+		err = req.validate(&a.cfg)
+	}
+	if err != nil {
+		writeHistoryError(w, err)
+		return
+	}
+
+	var responseEnd *time.Time
+	if req.EndTime != nil {
+		end := req.EndTime.Add(a.cfg.ResponseAtExtension)
+		responseEnd = &end
+	}
+	proj, cu := generateProjection(req.CU, a.cfg.EnableStatementCU, a.cfg.EnableStatsCU)
+	si := StatementInfo{StatementId: req.StatementID, Account: account}
+	record, err := si.SelectByStatementId(a.db.WithContext(r.Context()), &proj, req.StartTime, req.EndTime,
+		NonUserRawComment, cu, responseEnd, a.cfg.EnableStatementCU)
+	if err != nil {
+		writeHistoryError(w, err)
+		return
+	}
+	writeHistoryJSON(w, http.StatusOK, record)
+}
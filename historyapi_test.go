@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestWriteHistoryError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("%w: page_size exceeds 100", ErrInvalidParameter), http.StatusBadRequest},
+		{fmt.Errorf("%w: missing %s header", ErrPermissionDenied, accountHeader), http.StatusForbidden},
+		{gorm.ErrRecordNotFound, http.StatusNotFound},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{errors.New("broken pipe"), http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		writeHistoryError(w, tc.err)
+		if w.Code != tc.code {
+			t.Errorf("%v: got %d, want %d", tc.err, w.Code, tc.code)
+		}
+	}
+}
+
+func TestHistoryAPIMissingAccount(t *testing.T) {
+	capture, err := NewSQLCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/query_history/list", strings.NewReader("{}"))
+	w := httptest.NewRecorder()
+	NewQueryHistoryAPI(capture.DB(), DefaultQueryHistoryConfig()).Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || len(capture.Take()) != 0 {
+		t.Errorf("got %d %s, want %d before any query", w.Code, w.Body, http.StatusForbidden)
+	}
+}
+
+func TestCallerAccount(t *testing.T) {
+	open := DefaultQueryHistoryConfig()
+	tokens := DefaultQueryHistoryConfig()
+	tokens.AccountTokens = map[string]string{"t-sys": sysAccount, "t-acc1": "acc1"}
+	for _, tc := range []struct {
+		name          string
+		cfg           *QueryHistoryConfig
+		authorization string
+		declared      string
+		want          string
+		err           error
+	}{
+		{name: "trusted header", cfg: &open, declared: "acc1", want: "acc1"},
+		{name: "missing header", cfg: &open, err: ErrPermissionDenied},
+		{name: "token", cfg: &tokens, authorization: "Bearer t-acc1", want: "acc1"},
+		{name: "token and its account", cfg: &tokens, authorization: "Bearer t-sys", declared: sysAccount, want: sysAccount},
+		{name: "no token", cfg: &tokens, declared: sysAccount, err: ErrUnauthenticated},
+		{name: "unknown token", cfg: &tokens, authorization: "Bearer t-x", err: ErrUnauthenticated},
+		{name: "not bearer", cfg: &tokens, authorization: "Basic t-sys", err: ErrUnauthenticated},
+		{name: "another account", cfg: &tokens, authorization: "Bearer t-acc1", declared: sysAccount, err: ErrPermissionDenied},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.cfg.callerAccount(tc.authorization, tc.declared, accountHeader+" header")
+			if got != tc.want || !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+				t.Errorf("got %q %v, want %q %v", got, err, tc.want, tc.err)
+			}
+		})
+	}
+}
+
+func TestHistoryAPITokens(t *testing.T) {
+	capture, err := NewSQLCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultQueryHistoryConfig()
+	cfg.AccountTokens = map[string]string{"t-acc1": "acc1"}
+	handler := NewQueryHistoryAPI(capture.DB(), cfg).Handler()
+	for _, tc := range []struct {
+		header map[string]string
+		code   int
+	}{
+		{map[string]string{accountHeader: sysAccount}, http.StatusUnauthorized},
+		{map[string]string{"Authorization": "Bearer t-acc1", accountHeader: sysAccount}, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/query_history/list", strings.NewReader("{}"))
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.code || len(capture.Take()) != 0 {
+			t.Errorf("%v: got %d %s, want %d before any query", tc.header, w.Code, w.Body, tc.code)
+		}
+	}
+}
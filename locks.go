@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+const (
+	lockStatusWait     = "wait"
+	lockStatusAcquired = "acquired"
+	// defaultHolderLookback is how far statement_info is searched for a holder whose
+	// transaction start is unknown.
+	defaultHolderLookback = time.Hour
+)
+
+// lockRow is one row of MO's mo_locks() table function.
+type lockRow struct {
+	CnId        string
+	TxnId       string
+	TableId     uint64
+	LockKey     string
+	LockContent string
+	LockMode    string
+	LockStatus  string
+}
+
+// txnRow is one row of MO's mo_transactions() table function.
+type txnRow struct {
+	TxnId    string
+	CreateTs string
+}
+
+// lockProcess is the part of processlist() that links a transaction to its session.
+type lockProcess struct {
+	ConnId      uint64
+	SessionId   string
+	Account     string
+	User        string
+	TxnId       string
+	StatementId string
+	Info        string
+	QueryStart  string
+}
+
+type lockTable struct {
+	RelId       uint64
+	Reldatabase string
+	Relname     string
+}
+
+// LockWait is one transaction waiting for a lock and the transaction holding it.
+type LockWait struct {
+	Table       string `json:"table"`
+	TableId     uint64 `json:"table_id"`
+	LockKey     string `json:"lock_key"`
+	LockContent string `json:"lock_content"`
+	LockMode    string `json:"lock_mode"`
+
+	WaiterTxnId     string `json:"waiter_txn_id"`
+	WaiterSessionId string `json:"waiter_session_id,omitempty"`
+	WaiterUser      string `json:"waiter_user,omitempty"`
+	WaiterStatement string `json:"waiter_statement,omitempty"`
+	// WaitTime is since the waiting statement started, so an upper bound of the wait.
+	WaitTime time.Duration `json:"wait_time"`
+
+	HolderTxnId     string `json:"holder_txn_id"`
+	HolderSessionId string `json:"holder_session_id,omitempty"`
+	HolderConnId    uint64 `json:"holder_conn_id,omitempty"`
+	HolderUser      string `json:"holder_user,omitempty"`
+	HolderAccount   string `json:"holder_account,omitempty"`
+	// HolderAge is since the holding transaction started.
+	HolderAge time.Duration `json:"holder_age"`
+	// HolderStatementId and HolderStatement are the latest statement of the holding
+	// transaction in statement_info, often the one that took the lock.
+	HolderStatementId string `json:"holder_statement_id,omitempty"`
+	HolderStatement   string `json:"holder_statement,omitempty"`
+}
+
+// normalizeTxnID makes the transaction ids of mo_locks, mo_transactions, processlist
+// and statement_info comparable, some print them as uuid and some as plain hex.
+func normalizeTxnID(id string) string {
+	return strings.ToLower(strings.ReplaceAll(id, "-", ""))
+}
+
+// parseMOTime reads the timestamps of the MO table functions, which come as text
+// in the session time zone loc.
+func parseMOTime(s string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	// mo_transactions prints timestamps as physical-logical, the physical part in nanoseconds
+	physical, _, _ := strings.Cut(s, "-")
+	var ns int64
+	for _, c := range physical {
+		if c < '0' || c > '9' {
+			return time.Time{}, false
+		}
+		ns = ns*10 + int64(c-'0')
+	}
+	if ns == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// SelectLockWaits lists the transactions waiting for a lock with the holder of the lock,
+// the longest wait first. Only the sessions the connection may see in processlist() are
+// linked to their statements. loc is the session time zone, see Config.Location.
+func SelectLockWaits(db *gorm.DB, loc *time.Location, sqlComment string) ([]LockWait, error) {
+	var locks []lockRow
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("mo_locks() a").
+		Select("cn_id, txn_id, table_id, lock_key, lock_content, lock_mode, lock_status").
+		Scan(&locks).Error; err != nil {
+		return nil, err
+	}
+
+	type lockID struct {
+		tableID uint64
+		content string
+	}
+	holders := make(map[lockID][]lockRow)
+	var waiters []lockRow
+	tableIDs := make(map[uint64]bool)
+	for _, l := range locks {
+		switch strings.ToLower(l.LockStatus) {
+		case lockStatusWait:
+			waiters = append(waiters, l)
+			tableIDs[l.TableId] = true
+		case lockStatusAcquired:
+			id := lockID{l.TableId, l.LockContent}
+			holders[id] = append(holders[id], l)
+		}
+	}
+	if len(waiters) == 0 {
+		return []LockWait{}, nil
+	}
+
+	var (
+		txns   []txnRow
+		procs  []lockProcess
+		tables []lockTable
+	)
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("mo_transactions() a").
+		Select("txn_id, create_ts").Scan(&txns).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("processlist() a").
+		Select("conn_id, session_id, account, `user`, txn_id, statement_id, info, query_start").
+		Scan(&procs).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(tableIDs))
+	for id := range tableIDs {
+		ids = append(ids, id)
+	}
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("mo_catalog.mo_tables").
+		Select("rel_id, reldatabase, relname").Where("rel_id in ?", ids).Scan(&tables).Error; err != nil {
+		return nil, err
+	}
+
+	txnStart := make(map[string]time.Time, len(txns))
+	for _, t := range txns {
+		if ts, ok := parseMOTime(t.CreateTs, loc); ok {
+			txnStart[normalizeTxnID(t.TxnId)] = ts
+		}
+	}
+	procByTxn := make(map[string]lockProcess, len(procs))
+	for _, p := range procs {
+		if p.TxnId != "" {
+			procByTxn[normalizeTxnID(p.TxnId)] = p
+		}
+	}
+	tableNames := make(map[uint64]string, len(tables))
+	for _, t := range tables {
+		tableNames[t.RelId] = t.Reldatabase + "." + t.Relname
+	}
+
+	now := time.Now()
+	waits := make([]LockWait, 0, len(waiters))
+	holderTxns := make(map[string]bool)
+	for _, w := range waiters {
+		wait := LockWait{
+			Table:       tableNames[w.TableId],
+			TableId:     w.TableId,
+			LockKey:     w.LockKey,
+			LockContent: w.LockContent,
+			LockMode:    w.LockMode,
+			WaiterTxnId: w.TxnId,
+		}
+		if p, ok := procByTxn[normalizeTxnID(w.TxnId)]; ok {
+			wait.WaiterSessionId, wait.WaiterUser, wait.WaiterStatement = p.SessionId, p.User, p.Info
+			if start, ok := parseMOTime(p.QueryStart, loc); ok {
+				wait.WaitTime = now.Sub(start)
+			}
+		}
+		for _, h := range holders[lockID{w.TableId, w.LockContent}] {
+			if normalizeTxnID(h.TxnId) == normalizeTxnID(w.TxnId) {
+				continue
+			}
+			wait.HolderTxnId = h.TxnId
+			if p, ok := procByTxn[normalizeTxnID(h.TxnId)]; ok {
+				wait.HolderSessionId, wait.HolderConnId = p.SessionId, p.ConnId
+				wait.HolderUser, wait.HolderAccount = p.User, p.Account
+			}
+			if start, ok := txnStart[normalizeTxnID(h.TxnId)]; ok {
+				wait.HolderAge = now.Sub(start)
+			}
+			holderTxns[h.TxnId] = true
+			break
+		}
+		waits = append(waits, wait)
+	}
+
+	if len(holderTxns) > 0 {
+		// statement_info is only read back to the start of the oldest holder
+		since := now.Add(-defaultHolderLookback)
+		for _, w := range waits {
+			if start := now.Add(-w.HolderAge); w.HolderAge > 0 && start.Before(since) {
+				since = start
+			}
+		}
+		if err := fillHolderStatements(db, waits, holderTxns, since, sqlComment); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(waits, func(i, j int) bool { return waits[i].WaitTime > waits[j].WaitTime })
+	return waits, nil
+}
+
+// fillHolderStatements reads the latest statement of every holding transaction from statement_info.
+func fillHolderStatements(db *gorm.DB, waits []LockWait, holderTxns map[string]bool, since time.Time, sqlComment string) error {
+	txnIDs := make([]string, 0, 2*len(holderTxns))
+	for id := range holderTxns {
+		txnIDs = append(txnIDs, id)
+		if hex := normalizeTxnID(id); len(hex) == 32 {
+			txnIDs = append(txnIDs, hex[:8]+"-"+hex[8:12]+"-"+hex[12:16]+"-"+hex[16:20]+"-"+hex[20:])
+		}
+	}
+	var records []StatementInfo
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).
+		Select("transaction_id, statement_id, statement, request_at").
+		Where("request_at >= ? and transaction_id in ?", since, txnIDs).Order("request_at").Scan(&records).Error; err != nil {
+		return err
+	}
+	latest := make(map[string]StatementInfo, len(records))
+	for _, r := range records {
+		latest[normalizeTxnID(r.TransactionId)] = r
+	}
+	for i := range waits {
+		if r, ok := latest[normalizeTxnID(waits[i].HolderTxnId)]; ok {
+			waits[i].HolderStatementId, waits[i].HolderStatement = r.StatementId, r.Statement
+		}
+	}
+	return nil
+}
+
+// KillLockHolder closes the session holding the lock of w, which rolls its transaction
+// back and releases the lock. See KillSession for who may kill it.
+func KillLockHolder(db *gorm.DB, w LockWait, sqlComment string) error {
+	if w.HolderSessionId == "" {
+		return errors.New("the session of the lock holder is unknown")
+	}
+	return StatementInfo{SessionId: w.HolderSessionId}.KillSession(db, sqlComment)
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
 	"time"
 
 	"go.uber.org/zap"
@@ -12,9 +15,20 @@ import (
 
 var logger Logger
 
+// StatUnit is one point of the legacy stat_ts series queries.
+type StatUnit struct {
+	StatTS string  `gorm:"not null;type:varchar(32)" json:"stat_ts"`
+	Type   string  `gorm:"not null;type:varchar(32)" json:"type,omitempty"`
+	Value  float64 `gorm:"type:double" json:"value"`
+}
+
 func main() {
-	testContextTimeout()
-	//testNullText()
+	if err := runCLI(os.Args[1:]); err != nil {
+		if !errors.Is(err, errUsage) && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
 }
 
 func testNullText() {
@@ -109,6 +123,7 @@ func testAccount() {
 		Database:    "mysql",
 		PPV2Enabled: false,
 		ClientIP:    "",
+		TimeZone:    "Asia/Shanghai",
 	}
 
 	logger = NewLogger(zap.NewExample())
@@ -119,11 +134,6 @@ func testAccount() {
 		return
 	}
 
-	type StatUnit struct {
-		StatTS string  `gorm:"not null;type:varchar(32)" json:"stat_ts"`
-		Type   string  `gorm:"not null;type:varchar(32)" json:"type,omitempty"`
-		Value  float64 `gorm:"type:double" json:"value"`
-	}
 	record := make([]StatUnit, 0)
 
 	sql := "/* cloud_nonuser */ USE system_metrics; /* cloud_nonuser */ /* QPS */ SELECT `stat_ts`, SUM(`value`)/300 as value FROM(SELECT concat(DATE_FORMAT(date_add(`collecttime`,Interval 5 MINUTE),'%Y-%m-%d %H'),':',LPAD(CAST(5 * floor(minute(date_add(`collecttime`,Interval 5 MINUTE)) / 5) as int),2,0),':00') AS stat_ts, sum(`value`) AS value, `node` FROM sql_statement_total WHERE `collecttime` >= '2024-03-25 18:40:16' AND `collecttime` <= '2024-03-25 19:20:16' GROUP BY `node`, concat(DATE_FORMAT(date_add(`collecttime`,Interval 5 MINUTE),'%Y-%m-%d %H'),':',LPAD(CAST(5 * floor(minute(date_add(`collecttime`,Interval 5 MINUTE)) / 5) as int),2,0),':00')  ORDER BY concat(DATE_FORMAT(date_add(`collecttime`,Interval 5 MINUTE),'%Y-%m-%d %H'),':',LPAD(CAST(5 * floor(minute(date_add(`collecttime`,Interval 5 MINUTE)) / 5) as int),2,0),':00') LIMIT 100000)t GROUP BY `stat_ts`"
@@ -131,8 +141,10 @@ func testAccount() {
 		logger.Error(ctx, "error", err)
 		return
 	}
+	Render(os.Stdout, record, RenderOptions{Format: OutputTable})
 
-	testToSqlUsage(ctx, db)
+	loc, _ := dbCfg.Location()
+	testToSqlUsage(ctx, db, loc)
 
 	logger.Warn(ctx, "====== Done =======")
 	if conn, err := db.DB(); err != nil {
@@ -144,7 +156,7 @@ func testAccount() {
 	//time.Sleep(time.Hour)
 }
 
-func testToSqlUsage(ctx context.Context, userDB *gorm.DB) {
+func testToSqlUsage(ctx context.Context, userDB *gorm.DB, loc *time.Location) {
 
 	type request struct {
 		CU *uint
@@ -162,8 +174,13 @@ func testToSqlUsage(ctx context.Context, userDB *gorm.DB) {
 
 	// cond, args, order, limit, offset := generateFilters(&req, accountID)
 	logger.Warn(ctx, "==== testToSqlUsage: Step 1/2 =====")
+	start, end, err := Between(time.Date(2024, 3, 25, 18, 40, 16, 0, loc), time.Date(2024, 3, 25, 19, 20, 16, 0, loc)).Resolve(time.Now())
+	if err != nil {
+		logger.Error(ctx, "error", err)
+		return
+	}
 	cond, args, order, limit, offset := "1=1 AND request_at between ? and ? ",
-		[]any{"2024-03-25 18:40:16", "2024-03-25 19:20:16"}, "request_at desc",
+		[]any{start, end}, "request_at desc",
 		uint(20), uint(0)
 	// joinCond, joinArgs := generateJoinFilters(&req, accountID, h.cfg.ResponseAtExtension)
 	joinCond, joinArgs := "account = ? ", []any{"query_tae_table"}
@@ -178,16 +195,14 @@ func testToSqlUsage(ctx context.Context, userDB *gorm.DB) {
 	}
 
 	queryList, total, err := si.SelectStatements(userDB, proj, cond, args, order, limit, offset, NonUserRawComment, cu, req.CU, joinCond, joinArgs, false /*h.cfg.EnableStatementCU*/)
-	logger.Info(ctx, "queryList: %s", queryList)
+	Render(os.Stdout, queryList, RenderOptions{Format: OutputTable})
 	logger.Info(ctx, "total: %d", total)
 	logger.Info(ctx, "err: %v", err)
 
 	// Step 2/2
 	logger.Warn(ctx, "==== testToSqlUsage: Step 2/2 =====")
 	//proj, cu := h.generateProjection(&DescribeQueryHistoryRequest{}, h.cfg.EnableStatementCU, needCU, h.cfg.EnableStatsCU)
-	start, end := "2024-03-25 18:40:16", "2024-03-25 19:20:16"
-	responseEnd := ""
-	detail, err := si.SelectByStatementId(userDB, &proj, &start, &end, NonUserRawComment, cu, &responseEnd, false /*h.cfg.EnableStatementCU*/)
-	logger.Info(ctx, "detail: %s", detail)
+	detail, err := si.SelectByStatementId(userDB, &proj, &start, &end, NonUserRawComment, cu, nil, false /*h.cfg.EnableStatementCU*/)
+	Render(os.Stdout, detail, RenderOptions{Format: OutputTable})
 	logger.Info(ctx, "err: %v", err)
 }
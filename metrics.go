@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+const (
+	metricsDatabase    = "system_metrics"
+	metricTimeCol      = "collecttime"
+	metricValueCol     = "value"
+	defaultMetricStep  = 5 * time.Minute
+	defaultMetricAggFn = "sum"
+)
+
+// metricAggFuncs are the aggregations allowed for MetricQuery.SeriesAgg and MetricQuery.Agg.
+var metricAggFuncs = map[string]string{
+	"sum":   "sum",
+	"avg":   "avg",
+	"max":   "max",
+	"min":   "min",
+	"count": "count",
+}
+
+var identRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type LabelMatcher struct {
+	Name string
+	// Op is one of "=", "!=", "=~" and "!~", regular expressions are fully anchored like in PromQL.
+	Op    string
+	Value string
+}
+
+// labelMatchOps maps the match operators to conditions on the label column.
+var labelMatchOps = map[string]string{
+	"=":  "`%s` = ?",
+	"!=": "`%s` != ?",
+	"=~": "regexp_like(`%s`, ?)",
+	"!~": "not regexp_like(`%s`, ?)",
+}
+
+func (m LabelMatcher) cond() (string, any) {
+	value := m.Value
+	if m.Op == "=~" || m.Op == "!~" {
+		value = "^(?:" + value + ")$"
+	}
+	return fmt.Sprintf(labelMatchOps[m.Op], m.Name), value
+}
+
+// MetricQuery describes a bucketed query over one table of system_metrics.
+// Raw samples are first aggregated per series and step with SeriesAgg,
+// a series being identified by SeriesBy, then the series are aggregated
+// with Agg into one result series per distinct GroupBy labels.
+type MetricQuery struct {
+	// Table is the metric table, for example sql_statement_total.
+	Table    string
+	Start    time.Time
+	End      time.Time
+	Step     time.Duration
+	Matchers []LabelMatcher
+	// SeriesBy is usually the labels of the raw series, like node.
+	SeriesBy  []string
+	SeriesAgg string
+	GroupBy   []string
+	Agg       string
+	// PerSecond divides every value by the step in seconds.
+	PerSecond bool
+	// Fill adds the buckets without samples to every series.
+	Fill FillPolicy
+}
+
+type MetricPoint struct {
+	// Timestamp is the start of the bucket in unix seconds.
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+	// Null marks a bucket without samples, see FillNull.
+	Null bool `json:"-"`
+}
+
+type MetricSeries struct {
+	Labels map[string]string `json:"labels"`
+	Points []MetricPoint     `json:"points"`
+}
+
+// invalidQuery marks err as a problem of the query rather than of running it.
+func invalidQuery(err error) error {
+	return fmt.Errorf("%w: %v", ErrInvalidParameter, err)
+}
+
+func (q *MetricQuery) validate() error {
+	if !identRegexp.MatchString(q.Table) {
+		return fmt.Errorf("invalid metric table: %s", q.Table)
+	}
+	if q.Start.IsZero() || q.End.IsZero() || !q.Start.Before(q.End) {
+		return errors.New("Invalid time range")
+	}
+	if q.Step == 0 {
+		q.Step = defaultMetricStep
+	}
+	if q.Step < time.Second || q.Step%time.Second != 0 {
+		return fmt.Errorf("invalid step: %s", q.Step)
+	}
+	if q.SeriesAgg == "" {
+		q.SeriesAgg = defaultMetricAggFn
+	}
+	if q.Agg == "" {
+		q.Agg = defaultMetricAggFn
+	}
+	if !q.Fill.valid() {
+		return fmt.Errorf("unknown fill policy: %s", q.Fill)
+	}
+	for _, fn := range []string{q.SeriesAgg, q.Agg} {
+		if _, ok := metricAggFuncs[fn]; !ok {
+			return fmt.Errorf("unknown aggregation: %s", fn)
+		}
+	}
+	for _, labels := range [][]string{q.SeriesBy, q.GroupBy} {
+		for _, l := range labels {
+			if !identRegexp.MatchString(l) {
+				return fmt.Errorf("invalid label: %s", l)
+			}
+		}
+	}
+	for _, m := range q.Matchers {
+		if !identRegexp.MatchString(m.Name) {
+			return fmt.Errorf("invalid label: %s", m.Name)
+		}
+		if _, ok := labelMatchOps[m.Op]; !ok {
+			return fmt.Errorf("unknown label match op: %s", m.Op)
+		}
+	}
+	return nil
+}
+
+func (q *MetricQuery) stepSeconds() int64 {
+	return int64(q.Step / time.Second)
+}
+
+func quoteIdents(names []string) []string {
+	quoted := make([]string, 0, len(names))
+	for _, n := range names {
+		quoted = append(quoted, "`"+n+"`")
+	}
+	return quoted
+}
+
+// unionLabels returns a followed by the labels of b missing in a.
+func unionLabels(a, b []string) []string {
+	out := append([]string{}, a...)
+	for _, l := range b {
+		found := false
+		for _, o := range out {
+			if o == l {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// query builds the two level aggregation, the result columns are the GroupBy labels, ts and value.
+// The buckets are counted in seconds from origin, the first multiple of step since the unix epoch
+// at or before Start. Origin is passed like Start and End, in the location of the driver, so the
+// buckets do not depend on the session time_zone the way unix_timestamp(collecttime) does.
+func (q *MetricQuery) query(db *gorm.DB, sqlComment string) *gorm.DB {
+	step := q.stepSeconds()
+	origin := floorDiv(q.Start.Unix(), step) * step
+	tsExpr := fmt.Sprintf("%d + CAST(floor(TIMESTAMPDIFF(SECOND, ?, `%s`)/%d) AS BIGINT)*%d", origin, metricTimeCol, step, step)
+
+	cond := fmt.Sprintf("`%s` >= ? and `%s` < ?", metricTimeCol, metricTimeCol)
+	args := []any{time.Unix(origin, 0), q.Start, q.End}
+	for _, m := range q.Matchers {
+		c, arg := m.cond()
+		cond += " and " + c
+		args = append(args, arg)
+	}
+
+	labels := quoteIdents(unionLabels(q.SeriesBy, q.GroupBy))
+	series := strings.Join(append([]string{"ts"}, labels...), ", ")
+	sampleProj := append(append([]string{tsExpr + " AS ts"}, labels...), "`"+metricValueCol+"`")
+	samples := fmt.Sprintf("select %s from %s.%s where %s",
+		strings.Join(sampleProj, ", "), metricsDatabase, q.Table, cond)
+	tmpTableSQL := fmt.Sprintf("(select %s, %s(`%s`) AS `value` from (%s)s group by %s)t",
+		series, metricAggFuncs[q.SeriesAgg], metricValueCol, samples, series)
+
+	valueExpr := fmt.Sprintf("%s(`value`)", metricAggFuncs[q.Agg])
+	if q.PerSecond {
+		valueExpr = fmt.Sprintf("%s/%d", valueExpr, step)
+	}
+	outerGroup := append([]string{"ts"}, quoteIdents(q.GroupBy)...)
+	outerProj := append(quoteIdents(q.GroupBy), "ts", valueExpr+" AS `value`")
+	order := append(quoteIdents(q.GroupBy), "ts")
+	return db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(tmpTableSQL, args...).
+		Select(strings.Join(outerProj, ", ")).Group(strings.Join(outerGroup, ", ")).Order(strings.Join(order, ", "))
+}
+
+// QueryMetrics runs q and returns one series per distinct GroupBy labels, ordered by labels.
+func QueryMetrics(db *gorm.DB, q MetricQuery, sqlComment string) ([]MetricSeries, error) {
+	if err := q.validate(); err != nil {
+		return nil, invalidQuery(err)
+	}
+	rows, err := q.query(db, sqlComment).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series, err := scanMetricSeries(rows, q.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+	for i := range series {
+		points := series[i].Points
+		sort.Slice(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+		series[i].Points = FillSeries(points, q.Start, q.End, q.Step, q.Fill)
+	}
+	return series, nil
+}
+
+// scanMetricSeries reads rows of the label columns, ts and value into one series per distinct labels.
+func scanMetricSeries(rows *sql.Rows, labelNames []string) ([]MetricSeries, error) {
+	var (
+		series []MetricSeries
+		index  = make(map[string]int)
+	)
+	labels := make([]sql.NullString, len(labelNames))
+	for rows.Next() {
+		var (
+			ts    int64
+			value sql.NullFloat64
+		)
+		dest := make([]any, 0, len(labels)+2)
+		for i := range labels {
+			dest = append(dest, &labels[i])
+		}
+		dest = append(dest, &ts, &value)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		var key strings.Builder
+		for _, l := range labels {
+			key.WriteString(l.String)
+			key.WriteByte(0)
+		}
+		i, ok := index[key.String()]
+		if !ok {
+			ls := make(map[string]string, len(labels))
+			for j, l := range labels {
+				ls[labelNames[j]] = l.String
+			}
+			i = len(series)
+			index[key.String()] = i
+			series = append(series, MetricSeries{Labels: ls})
+		}
+		series[i].Points = append(series[i].Points, MetricPoint{Timestamp: ts, Value: value.Float64, Null: !value.Valid})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryMetricsBuckets(t *testing.T) {
+	f := StartFakeMO(t, FakeMOOptions{})
+	if err := f.CreateMetricTable("test_metric", "node"); err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []float64{1, 2, 3, 4} {
+		if err := f.Insert(metricsDatabase+".test_metric", map[string]any{
+			metricTimeCol: fixtureStart.Add(time.Duration(i) * 30 * time.Second), metricValueCol: v, "node": fmt.Sprintf("cn%d", i%2),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Start is inside the first bucket, the sample at fixtureStart is outside the range
+	for _, tc := range []struct {
+		groupBy []string
+		want    string
+	}{
+		{nil, "1711389600:2,1711389660:7"},
+		{[]string{"node"}, "1711389660:3 1711389600:2,1711389660:4"},
+	} {
+		series, err := QueryMetrics(f.DB(), MetricQuery{
+			Table:   "test_metric",
+			Start:   fixtureStart.Add(10 * time.Second),
+			End:     fixtureStart.Add(2 * time.Minute),
+			Step:    time.Minute,
+			GroupBy: tc.groupBy,
+		}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range series {
+			var points []string
+			for _, p := range s.Points {
+				points = append(points, fmt.Sprintf("%d:%g", p.Timestamp, p.Value))
+			}
+			got = append(got, strings.Join(points, ","))
+		}
+		if s := strings.Join(got, " "); s != tc.want {
+			t.Errorf("by %v: got %s, want %s", tc.groupBy, s, tc.want)
+		}
+	}
+}
+
+func TestQueryMetricsBucketOrigin(t *testing.T) {
+	capture, err := NewSQLCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := QueryMetrics(capture.DB(), MetricQuery{
+		Table: "test_metric",
+		Start: fixtureStart.Add(10 * time.Second),
+		End:   fixtureStart.Add(time.Hour),
+		Step:  5 * time.Minute,
+	}, ""); err != nil {
+		t.Fatal(err)
+	}
+	// the buckets count from a time argument, formatted like Start and End by the driver,
+	// rather than from unix_timestamp, which reads collecttime in the session time_zone
+	stmts := capture.Take()
+	if len(stmts) != 1 || strings.Contains(stmts[0].SQL, "unix_timestamp") ||
+		len(stmts[0].Args) == 0 || fmt.Sprint(stmts[0].Args[0]) != fmt.Sprint(time.Unix(fixtureStart.Unix(), 0)) {
+		t.Fatalf("got %v, want one query bucketing from %s", stmts, fixtureStart)
+	}
+}
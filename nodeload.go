@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultHotspotRatio flags a node serving 1.5 times the mean load.
+const defaultHotspotRatio = 1.5
+
+type NodeLoadRequest struct {
+	// Account limits the load to one account, empty means all.
+	Account string
+	Start   time.Time
+	End     time.Time
+	// HotspotRatio flags the nodes whose statement count exceeds
+	// HotspotRatio times the mean count of all nodes.
+	HotspotRatio float64
+}
+
+// NodeLoad is the load of one CN, durations are in nanoseconds.
+type NodeLoad struct {
+	NodeUuid    string  `json:"node_uuid"`
+	NodeType    string  `json:"node_type"`
+	Count       int64   `json:"count"`
+	QPS         float64 `json:"qps"`
+	Share       float64 `json:"share"`
+	Errors      int64   `json:"errors"`
+	ErrorRate   float64 `json:"error_rate"`
+	P50         uint64  `json:"p50"`
+	P95         uint64  `json:"p95"`
+	P99         uint64  `json:"p99"`
+	MaxDuration uint64  `json:"max_duration"`
+	RowsRead    uint64  `json:"rows_read"`
+	BytesScan   uint64  `json:"bytes_scan"`
+	CU          float64 `json:"cu"`
+	Hotspot     bool    `json:"hotspot"`
+}
+
+type NodeLoadReport struct {
+	Nodes []NodeLoad `json:"nodes"`
+	Total int64      `json:"total"`
+	// Imbalance is the count of the busiest node over the mean count, 1 is even.
+	Imbalance float64  `json:"imbalance"`
+	Hotspots  []string `json:"hotspots"`
+}
+
+// SelectNodeLoad breaks the finished statements in [Start, End) down per node,
+// the busiest node first.
+func SelectNodeLoad(db *gorm.DB, req NodeLoadRequest, sqlComment string) (*NodeLoadReport, error) {
+	if req.Start.IsZero() || req.End.IsZero() || !req.Start.Before(req.End) {
+		return nil, errors.New("Invalid time range")
+	}
+	if req.HotspotRatio <= 0 {
+		req.HotspotRatio = defaultHotspotRatio
+	}
+	nodes := make([]NodeLoad, 0)
+	if err := selectStatementStats(db, "node_uuid, node_type", req.Account, req.Start, req.End, sqlComment).
+		Scan(&nodes).Error; err != nil {
+		return nil, err
+	}
+	return buildNodeLoadReport(nodes, req.End.Sub(req.Start), req.HotspotRatio), nil
+}
+
+func buildNodeLoadReport(nodes []NodeLoad, window time.Duration, ratio float64) *NodeLoadReport {
+	report := &NodeLoadReport{Nodes: nodes, Hotspots: []string{}}
+	if len(nodes) == 0 {
+		return report
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Count > nodes[j].Count })
+	for _, n := range nodes {
+		report.Total += n.Count
+	}
+	mean := float64(report.Total) / float64(len(nodes))
+	for i := range nodes {
+		n := &nodes[i]
+		n.QPS = float64(n.Count) / window.Seconds()
+		if report.Total > 0 {
+			n.Share = float64(n.Count) / float64(report.Total)
+		}
+		if len(nodes) > 1 && float64(n.Count) > ratio*mean {
+			n.Hotspot = true
+			report.Hotspots = append(report.Hotspots, n.NodeUuid)
+		}
+	}
+	if mean > 0 {
+		report.Imbalance = float64(nodes[0].Count) / mean
+	}
+	return report
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildNodeLoadReport(t *testing.T) {
+	nodes := func(counts ...int64) []NodeLoad {
+		ns := make([]NodeLoad, 0, len(counts))
+		for i, c := range counts {
+			ns = append(ns, NodeLoad{NodeUuid: fmt.Sprintf("cn%d", i+1), Count: c})
+		}
+		return ns
+	}
+	for _, tc := range []struct {
+		name  string
+		nodes []NodeLoad
+		ratio float64
+		// nodes are uuid:qps:share:hotspot, busiest first
+		want      string
+		total     int64
+		imbalance float64
+		hotspots  string
+	}{
+		{name: "no node", nodes: nodes(), ratio: 1.5},
+		{name: "single node", nodes: nodes(600), ratio: 1.5,
+			want: "cn1:10:1:false", total: 600, imbalance: 1},
+		{name: "even", nodes: nodes(300, 300), ratio: 1.5,
+			want: "cn1:5:0.5:false cn2:5:0.5:false", total: 600, imbalance: 1},
+		// mean 150, cn3 serves 2x the mean
+		{name: "hotspot", nodes: nodes(60, 90, 300), ratio: 1.5,
+			want:  "cn3:5:0.6666666666666666:true cn2:1.5:0.2:false cn1:1:0.13333333333333333:false",
+			total: 450, imbalance: 2, hotspots: "cn3"},
+		{name: "lower ratio", nodes: nodes(60, 90, 300), ratio: 0.5,
+			want:  "cn3:5:0.6666666666666666:true cn2:1.5:0.2:true cn1:1:0.13333333333333333:false",
+			total: 450, imbalance: 2, hotspots: "cn3,cn2"},
+		{name: "idle", nodes: nodes(0, 0), ratio: 1.5,
+			want: "cn1:0:0:false cn2:0:0:false"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report := buildNodeLoadReport(tc.nodes, time.Minute, tc.ratio)
+			var got []string
+			for _, n := range report.Nodes {
+				got = append(got, fmt.Sprintf("%s:%g:%g:%v", n.NodeUuid, n.QPS, n.Share, n.Hotspot))
+			}
+			if s := strings.Join(got, " "); s != tc.want {
+				t.Errorf("nodes\ngot  %s\nwant %s", s, tc.want)
+			}
+			if report.Total != tc.total || report.Imbalance != tc.imbalance || strings.Join(report.Hotspots, ",") != tc.hotspots {
+				t.Errorf("got total %d imbalance %g hotspots %v, want %d %g %s",
+					report.Total, report.Imbalance, report.Hotspots, tc.total, tc.imbalance, tc.hotspots)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: gorm_demo.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	QueryHistory_ListStatements_FullMethodName    = "/gorm_demo.v1.QueryHistory/ListStatements"
+	QueryHistory_DescribeStatement_FullMethodName = "/gorm_demo.v1.QueryHistory/DescribeStatement"
+	QueryHistory_ExportStatements_FullMethodName  = "/gorm_demo.v1.QueryHistory/ExportStatements"
+	QueryHistory_QueryMetricRange_FullMethodName  = "/gorm_demo.v1.QueryHistory/QueryMetricRange"
+)
+
+// QueryHistoryClient is the client API for QueryHistory service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// QueryHistory serves system.statement_info and system_metrics, the account of
+// the caller is read from the x-account metadata.
+type QueryHistoryClient interface {
+	ListStatements(ctx context.Context, in *ListStatementsRequest, opts ...grpc.CallOption) (*ListStatementsResponse, error)
+	DescribeStatement(ctx context.Context, in *DescribeStatementRequest, opts ...grpc.CallOption) (*Statement, error)
+	// ExportStatements streams the export file in chunks.
+	ExportStatements(ctx context.Context, in *ExportStatementsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportChunk], error)
+	QueryMetricRange(ctx context.Context, in *MetricRangeRequest, opts ...grpc.CallOption) (*MetricRangeResponse, error)
+}
+
+type queryHistoryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewQueryHistoryClient(cc grpc.ClientConnInterface) QueryHistoryClient {
+	return &queryHistoryClient{cc}
+}
+
+func (c *queryHistoryClient) ListStatements(ctx context.Context, in *ListStatementsRequest, opts ...grpc.CallOption) (*ListStatementsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListStatementsResponse)
+	err := c.cc.Invoke(ctx, QueryHistory_ListStatements_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryHistoryClient) DescribeStatement(ctx context.Context, in *DescribeStatementRequest, opts ...grpc.CallOption) (*Statement, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Statement)
+	err := c.cc.Invoke(ctx, QueryHistory_DescribeStatement_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *queryHistoryClient) ExportStatements(ctx context.Context, in *ExportStatementsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &QueryHistory_ServiceDesc.Streams[0], QueryHistory_ExportStatements_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportStatementsRequest, ExportChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type QueryHistory_ExportStatementsClient = grpc.ServerStreamingClient[ExportChunk]
+
+func (c *queryHistoryClient) QueryMetricRange(ctx context.Context, in *MetricRangeRequest, opts ...grpc.CallOption) (*MetricRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetricRangeResponse)
+	err := c.cc.Invoke(ctx, QueryHistory_QueryMetricRange_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// QueryHistoryServer is the server API for QueryHistory service.
+// All implementations must embed UnimplementedQueryHistoryServer
+// for forward compatibility.
+//
+// QueryHistory serves system.statement_info and system_metrics, the account of
+// the caller is read from the x-account metadata.
+type QueryHistoryServer interface {
+	ListStatements(context.Context, *ListStatementsRequest) (*ListStatementsResponse, error)
+	DescribeStatement(context.Context, *DescribeStatementRequest) (*Statement, error)
+	// ExportStatements streams the export file in chunks.
+	ExportStatements(*ExportStatementsRequest, grpc.ServerStreamingServer[ExportChunk]) error
+	QueryMetricRange(context.Context, *MetricRangeRequest) (*MetricRangeResponse, error)
+	mustEmbedUnimplementedQueryHistoryServer()
+}
+
+// UnimplementedQueryHistoryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedQueryHistoryServer struct{}
+
+func (UnimplementedQueryHistoryServer) ListStatements(context.Context, *ListStatementsRequest) (*ListStatementsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStatements not implemented")
+}
+func (UnimplementedQueryHistoryServer) DescribeStatement(context.Context, *DescribeStatementRequest) (*Statement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeStatement not implemented")
+}
+func (UnimplementedQueryHistoryServer) ExportStatements(*ExportStatementsRequest, grpc.ServerStreamingServer[ExportChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method ExportStatements not implemented")
+}
+func (UnimplementedQueryHistoryServer) QueryMetricRange(context.Context, *MetricRangeRequest) (*MetricRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryMetricRange not implemented")
+}
+func (UnimplementedQueryHistoryServer) mustEmbedUnimplementedQueryHistoryServer() {}
+func (UnimplementedQueryHistoryServer) testEmbeddedByValue()                      {}
+
+// UnsafeQueryHistoryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to QueryHistoryServer will
+// result in compilation errors.
+type UnsafeQueryHistoryServer interface {
+	mustEmbedUnimplementedQueryHistoryServer()
+}
+
+func RegisterQueryHistoryServer(s grpc.ServiceRegistrar, srv QueryHistoryServer) {
+	// If the following call pancis, it indicates UnimplementedQueryHistoryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&QueryHistory_ServiceDesc, srv)
+}
+
+func _QueryHistory_ListStatements_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStatementsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryHistoryServer).ListStatements(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QueryHistory_ListStatements_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryHistoryServer).ListStatements(ctx, req.(*ListStatementsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryHistory_DescribeStatement_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeStatementRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryHistoryServer).DescribeStatement(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QueryHistory_DescribeStatement_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryHistoryServer).DescribeStatement(ctx, req.(*DescribeStatementRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _QueryHistory_ExportStatements_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportStatementsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(QueryHistoryServer).ExportStatements(m, &grpc.GenericServerStream[ExportStatementsRequest, ExportChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type QueryHistory_ExportStatementsServer = grpc.ServerStreamingServer[ExportChunk]
+
+func _QueryHistory_QueryMetricRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetricRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QueryHistoryServer).QueryMetricRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: QueryHistory_QueryMetricRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QueryHistoryServer).QueryMetricRange(ctx, req.(*MetricRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// QueryHistory_ServiceDesc is the grpc.ServiceDesc for QueryHistory service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var QueryHistory_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gorm_demo.v1.QueryHistory",
+	HandlerType: (*QueryHistoryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListStatements",
+			Handler:    _QueryHistory_ListStatements_Handler,
+		},
+		{
+			MethodName: "DescribeStatement",
+			Handler:    _QueryHistory_DescribeStatement_Handler,
+		},
+		{
+			MethodName: "QueryMetricRange",
+			Handler:    _QueryHistory_QueryMetricRange_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportStatements",
+			Handler:       _QueryHistory_ExportStatements_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gorm_demo.proto",
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const defaultProfile = "default"
+
+// Profiles is the content of a config file, one connection Config per profile name.
+// Files ending with .json are read as json, anything else as yaml.
+type Profiles map[string]Config
+
+func LoadProfiles(path string) (Profiles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(Profiles)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &profiles)
+	} else {
+		err = yaml.Unmarshal(data, &profiles)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// Get returns the named profile, an empty name means "default".
+func (p Profiles) Get(name string) (Config, error) {
+	if name == "" {
+		name = defaultProfile
+	}
+	cfg, ok := p[name]
+	if !ok {
+		names := make([]string, 0, len(p))
+		for n := range p {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Config{}, fmt.Errorf("unknown profile %q, have %v", name, names)
+	}
+	return cfg, nil
+}
+
+// Open connects with the named profile through OpenDB.
+func (p Profiles) Open(name string, logger gormlogger.Interface, opts ...MysqlConfigOption) (*gorm.DB, error) {
+	cfg, err := p.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return OpenDB(cfg, logger, opts...)
+}
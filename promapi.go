@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+const (
+	promErrorBadData   = "bad_data"
+	promErrorExecution = "execution"
+
+	// maxPromPoints bounds the points per series of a range query, like Prometheus.
+	maxPromPoints = 11000
+)
+
+// PromAPI serves the Prometheus HTTP query API over system_metrics,
+// every query is tagged with NonUserComment.
+type PromAPI struct {
+	db                 *gorm.DB
+	cumulativeCounters bool
+}
+
+func NewPromAPI(db *gorm.DB, cumulativeCounters bool) *PromAPI {
+	return &PromAPI{db: db, cumulativeCounters: cumulativeCounters}
+}
+
+func (a *PromAPI) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/query", a.query)
+	mux.HandleFunc("/api/v1/query_range", a.queryRange)
+	mux.HandleFunc("/api/v1/labels", a.labels)
+	mux.HandleFunc("/api/v1/label/__name__/values", a.metricNames)
+	mux.HandleFunc("/api/v1/series", a.series)
+	return mux
+}
+
+type promResponse struct {
+	Status    string `json:"status"`
+	Data      any    `json:"data,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type promQueryData struct {
+	ResultType string `json:"resultType"`
+	Result     any    `json:"result"`
+}
+
+type promVectorResult struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]any            `json:"value"`
+}
+
+type promMatrixResult struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+func writePromJSON(w http.ResponseWriter, code int, resp promResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error(context.TODO(), "write response: %v", err)
+	}
+}
+
+func writePromData(w http.ResponseWriter, data any) {
+	writePromJSON(w, http.StatusOK, promResponse{Status: "success", Data: data})
+}
+
+// promErrorType tells the queries Prometheus rejects from the ones that failed to run.
+func promErrorType(err error) string {
+	if errors.Is(err, ErrInvalidParameter) {
+		return promErrorBadData
+	}
+	return promErrorExecution
+}
+
+func writePromError(w http.ResponseWriter, errType string, err error) {
+	code := http.StatusBadRequest
+	if errType == promErrorExecution {
+		code = http.StatusUnprocessableEntity
+	}
+	writePromJSON(w, code, promResponse{Status: "error", ErrorType: errType, Error: err.Error()})
+}
+
+// parsePromTime accepts unix seconds with optional fraction or RFC3339.
+func parsePromTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// parsePromStep accepts seconds with optional fraction or a duration like 5m.
+func parsePromStep(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return ParsePromDuration(s)
+}
+
+func formatPromValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (a *PromAPI) query(w http.ResponseWriter, r *http.Request) {
+	at, err := parsePromTime(r.FormValue("time"), time.Now())
+	if err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+	q := PromQuery{Query: r.FormValue("query"), End: at, CumulativeCounters: a.cumulativeCounters}
+	vector, err := QueryPromQL(a.db.WithContext(r.Context()), q, NonUserRawComment)
+	if err != nil {
+		writePromError(w, promErrorType(err), err)
+		return
+	}
+	result := make([]promVectorResult, 0, len(vector))
+	for _, s := range vector {
+		result = append(result, promVectorResult{Metric: s.Labels, Value: [2]any{s.Timestamp, formatPromValue(s.Value)}})
+	}
+	writePromData(w, promQueryData{ResultType: "vector", Result: result})
+}
+
+func (a *PromAPI) queryRange(w http.ResponseWriter, r *http.Request) {
+	start, err := parsePromTime(r.FormValue("start"), time.Time{})
+	if err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+	end, err := parsePromTime(r.FormValue("end"), time.Time{})
+	if err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+	step, err := parsePromStep(r.FormValue("step"))
+	if err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+	// the SQL works on whole seconds
+	step = step.Round(time.Second)
+	if step > 0 && end.Sub(start)/step > maxPromPoints {
+		writePromError(w, promErrorBadData, fmt.Errorf("exceeded maximum resolution of %d points per timeseries, try decreasing the query resolution (?step=XX)", maxPromPoints))
+		return
+	}
+	q := PromQuery{Query: r.FormValue("query"), Start: start, End: end, Step: step, CumulativeCounters: a.cumulativeCounters}
+	matrix, err := QueryPromQLRange(a.db.WithContext(r.Context()), q, NonUserRawComment)
+	if err != nil {
+		writePromError(w, promErrorType(err), err)
+		return
+	}
+	result := make([]promMatrixResult, 0, len(matrix))
+	for _, s := range matrix {
+		values := make([][2]any, 0, len(s.Points))
+		for _, p := range s.Points {
+			if !p.Null {
+				values = append(values, [2]any{p.Timestamp, formatPromValue(p.Value)})
+			}
+		}
+		result = append(result, promMatrixResult{Metric: s.Labels, Values: values})
+	}
+	writePromData(w, promQueryData{ResultType: "matrix", Result: result})
+}
+
+func (a *PromAPI) labels(w http.ResponseWriter, r *http.Request) {
+	labels := make([]string, 0)
+	if err := a.db.WithContext(r.Context()).Clauses(hints.CommentBefore("SELECT", NonUserRawComment)).
+		Table("information_schema.columns").Distinct("column_name").
+		Where("table_schema = ? and column_name not in ?", metricsDatabase, []string{metricTimeCol, metricValueCol}).
+		Pluck("column_name", &labels).Error; err != nil {
+		writePromError(w, promErrorExecution, err)
+		return
+	}
+	labels = append(labels, "__name__")
+	sort.Strings(labels)
+	writePromData(w, labels)
+}
+
+func (a *PromAPI) metricNames(w http.ResponseWriter, r *http.Request) {
+	names, err := metricTables(a.db.WithContext(r.Context()), NonUserRawComment)
+	if err != nil {
+		writePromError(w, promErrorExecution, err)
+		return
+	}
+	writePromData(w, names)
+}
+
+// series lists the label sets of the series matching any of the match[] selectors.
+func (a *PromAPI) series(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+	matches := r.Form["match[]"]
+	if len(matches) == 0 {
+		writePromError(w, promErrorBadData, errors.New("no match[] parameter provided"))
+		return
+	}
+	end, err := parsePromTime(r.FormValue("end"), time.Now())
+	if err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+	start, err := parsePromTime(r.FormValue("start"), end.Add(-time.Hour))
+	if err != nil {
+		writePromError(w, promErrorBadData, err)
+		return
+	}
+
+	db := a.db.WithContext(r.Context())
+	result := make([]map[string]string, 0)
+	for _, m := range matches {
+		expr, err := parsePromQL(m)
+		if err != nil {
+			writePromError(w, promErrorBadData, err)
+			return
+		}
+		sel, ok := expr.(*promSelector)
+		if !ok || sel.Range != 0 {
+			writePromError(w, promErrorBadData, fmt.Errorf("invalid series selector: %s", m))
+			return
+		}
+		series, err := selectSeriesLabels(db, sel, start, end, NonUserRawComment)
+		if err != nil {
+			writePromError(w, promErrorExecution, err)
+			return
+		}
+		result = append(result, series...)
+	}
+	writePromData(w, result)
+}
+
+func selectSeriesLabels(db *gorm.DB, sel *promSelector, start, end time.Time, sqlComment string) ([]map[string]string, error) {
+	if !identRegexp.MatchString(sel.Metric) {
+		return nil, fmt.Errorf("invalid metric name: %s", sel.Metric)
+	}
+	labels, err := metricLabelColumns(db, sel.Metric, sqlComment)
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return []map[string]string{}, nil
+	}
+	cond := fmt.Sprintf("`%s` >= ? and `%s` <= ?", metricTimeCol, metricTimeCol)
+	args := []any{start, end}
+	for _, m := range sel.Matchers {
+		if !containsLabel(labels, m.Name) {
+			return nil, fmt.Errorf("unknown label %s of %s", m.Name, sel.Metric)
+		}
+		c, arg := m.cond()
+		cond += " and " + c
+		args = append(args, arg)
+	}
+	rows := make([]map[string]any, 0)
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(metricsDatabase+"."+sel.Metric).
+		Distinct(strings.Join(quoteIdents(labels), ", ")).Where(cond, args...).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	series := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		ls := map[string]string{"__name__": sel.Metric}
+		for _, l := range labels {
+			if v := row[l]; v != nil {
+				ls[l] = fmt.Sprint(v)
+			}
+		}
+		series = append(series, ls)
+	}
+	return series, nil
+}
+
+// metricTables lists the tables of system_metrics.
+func metricTables(db *gorm.DB, sqlComment string) ([]string, error) {
+	names := make([]string, 0)
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("information_schema.tables").
+		Where("table_schema = ?", metricsDatabase).Order("table_name").
+		Pluck("table_name", &names).Error; err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// serveHTTP serves handler on addr until ctx is done.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPromAPIErrors(t *testing.T) {
+	f := StartFakeMO(t, FakeMOOptions{})
+	if err := f.CreateMetricTable("test_metric", "node"); err != nil {
+		t.Fatal(err)
+	}
+	api := NewPromAPI(f.DB(), false)
+
+	get := func(path string, params url.Values) (int, promResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		api.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path+"?"+params.Encode(), nil))
+		var resp promResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", w.Body, err)
+		}
+		return w.Code, resp
+	}
+	rangeParams := func(query string) url.Values {
+		return url.Values{"query": {query}, "start": {"1711389600"}, "end": {"1711393200"}, "step": {"60"}}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		params  url.Values
+		code    int
+		errType string
+	}{
+		{name: "query parse", path: "/api/v1/query", params: url.Values{"query": {"rate(test_metric"}},
+			code: http.StatusBadRequest, errType: promErrorBadData},
+		{name: "range parse", path: "/api/v1/query_range", params: rangeParams("sum by (node (test_metric)"),
+			code: http.StatusBadRequest, errType: promErrorBadData},
+		{name: "unknown metric", path: "/api/v1/query_range", params: rangeParams("rate(no_metric[5m])"),
+			code: http.StatusBadRequest, errType: promErrorBadData},
+		{name: "unknown label", path: "/api/v1/query", params: url.Values{"query": {`test_metric{pod="a"}`}},
+			code: http.StatusBadRequest, errType: promErrorBadData},
+		{name: "too many points", path: "/api/v1/query_range",
+			params: url.Values{"query": {"test_metric"}, "start": {"1711389600"}, "end": {"1711400601"}, "step": {"1"}},
+			code:   http.StatusBadRequest, errType: promErrorBadData},
+		{name: "max points", path: "/api/v1/query_range",
+			params: url.Values{"query": {"test_metric"}, "start": {"1711389600"}, "end": {"1711400600"}, "step": {"1s"}},
+			code:   http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, resp := get(tc.path, tc.params)
+			if code != tc.code || resp.ErrorType != tc.errType {
+				t.Errorf("got %d %s (%s), want %d %s", code, resp.ErrorType, resp.Error, tc.code, tc.errType)
+			}
+		})
+	}
+
+	// a query that parses but can not run is an execution error
+	f.Close()
+	code, resp := get("/api/v1/query", url.Values{"query": {"test_metric"}})
+	if code != http.StatusUnprocessableEntity || resp.ErrorType != promErrorExecution {
+		t.Errorf("got %d %s (%s), want %d %s", code, resp.ErrorType, resp.Error, http.StatusUnprocessableEntity, promErrorExecution)
+	}
+}
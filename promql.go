@@ -0,0 +1,665 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+// promLookback is how far back an instant selector looks for the latest sample.
+const promLookback = 5 * time.Minute
+
+var (
+	promAggOps = map[string]bool{"sum": true, "avg": true, "max": true, "min": true, "count": true, "topk": true}
+	promFuncs  = map[string]bool{"rate": true, "increase": true}
+)
+
+type promExpr interface{}
+
+type promSelector struct {
+	Metric   string
+	Matchers []LabelMatcher
+	Range    time.Duration
+	Offset   time.Duration
+}
+
+type promCall struct {
+	Func string
+	Arg  *promSelector
+}
+
+type promAggregate struct {
+	Op    string
+	By    []string
+	Param int
+	Expr  promExpr
+}
+
+const (
+	promTokIdent = iota
+	promTokString
+	promTokNumber
+	promTokDuration
+	promTokPunct
+	promTokEOF
+)
+
+type promToken struct {
+	kind int
+	text string
+}
+
+func lexPromQL(q string) ([]promToken, error) {
+	var toks []promToken
+	r := []rune(q)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || c == '_' || c == ':':
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_' || r[j] == ':') {
+				j++
+			}
+			toks = append(toks, promToken{promTokIdent, string(r[i:j])})
+			i = j
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.') {
+				j++
+			}
+			if j < len(r) && unicode.IsLetter(r[j]) {
+				for j < len(r) && (unicode.IsDigit(r[j]) || unicode.IsLetter(r[j])) {
+					j++
+				}
+				toks = append(toks, promToken{promTokDuration, string(r[i:j])})
+			} else {
+				toks = append(toks, promToken{promTokNumber, string(r[i:j])})
+			}
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(r) && r[j] != c {
+				if r[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(r) {
+				return nil, errors.New("unterminated string")
+			}
+			body := string(r[i+1 : j])
+			if c == '\'' {
+				body = strings.ReplaceAll(strings.ReplaceAll(body, `\'`, `'`), `"`, `\"`)
+			}
+			s, err := strconv.Unquote(`"` + body + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %v", string(r[i:j+1]), err)
+			}
+			toks = append(toks, promToken{promTokString, s})
+			i = j + 1
+		default:
+			if i+1 < len(r) {
+				if two := string(r[i : i+2]); two == "!=" || two == "=~" || two == "!~" {
+					toks = append(toks, promToken{promTokPunct, two})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("{}()[],=", c) {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, promToken{promTokPunct, string(c)})
+			i++
+		}
+	}
+	return append(toks, promToken{kind: promTokEOF}), nil
+}
+
+var promDurationRegexp = regexp.MustCompile(`(\d+)(ms|s|m|h|d|w|y)`)
+
+var promDurationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+// ParsePromDuration parses durations like 5m or 1h30m.
+func ParsePromDuration(s string) (time.Duration, error) {
+	matches := promDurationRegexp.FindAllStringSubmatchIndex(s, -1)
+	var (
+		d   time.Duration
+		pos int
+	)
+	for _, m := range matches {
+		if m[0] != pos {
+			break
+		}
+		n, err := strconv.ParseInt(s[m[2]:m[3]], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(n) * promDurationUnits[s[m[4]:m[5]]]
+		pos = m[1]
+	}
+	if len(matches) == 0 || pos != len(s) {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return d, nil
+}
+
+type promParser struct {
+	toks []promToken
+	pos  int
+}
+
+// parsePromQL parses the supported subset: selectors with label matchers,
+// range and offset, rate and increase, sum/avg/max/min/count/topk with by.
+func parsePromQL(q string) (promExpr, error) {
+	toks, err := lexPromQL(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &promParser{toks: toks}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != promTokEOF {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	return expr, nil
+}
+
+func (p *promParser) peek() promToken {
+	return p.toks[p.pos]
+}
+
+func (p *promParser) next() promToken {
+	t := p.toks[p.pos]
+	if t.kind != promTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *promParser) expect(kind int, text string) (promToken, error) {
+	t := p.next()
+	if t.kind != kind || (text != "" && t.text != text) {
+		if t.kind == promTokEOF {
+			return t, errors.New("unexpected end of query")
+		}
+		return t, fmt.Errorf("unexpected %q", t.text)
+	}
+	return t, nil
+}
+
+func (p *promParser) parseExpr() (promExpr, error) {
+	t := p.peek()
+	if t.kind != promTokIdent {
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+	after := p.toks[p.pos+1]
+	switch {
+	case promAggOps[t.text] && (after.text == "(" || after.text == "by" || after.text == "without"):
+		return p.parseAggregate()
+	case promFuncs[t.text] && after.text == "(":
+		return p.parseCall()
+	default:
+		return p.parseSelector()
+	}
+}
+
+func (p *promParser) parseAggregate() (promExpr, error) {
+	agg := &promAggregate{Op: p.next().text}
+	parseBy := func() error {
+		if t := p.peek(); t.kind == promTokIdent && t.text == "without" {
+			return errors.New("without is not supported")
+		}
+		if t := p.peek(); t.kind != promTokIdent || t.text != "by" {
+			return nil
+		}
+		p.next()
+		labels, err := p.parseLabelList()
+		agg.By = labels
+		return err
+	}
+	if err := parseBy(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(promTokPunct, "("); err != nil {
+		return nil, err
+	}
+	if agg.Op == "topk" {
+		t, err := p.expect(promTokNumber, "")
+		if err != nil {
+			return nil, err
+		}
+		if agg.Param, err = strconv.Atoi(t.text); err != nil || agg.Param <= 0 {
+			return nil, fmt.Errorf("invalid topk parameter: %s", t.text)
+		}
+		if _, err := p.expect(promTokPunct, ","); err != nil {
+			return nil, err
+		}
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	agg.Expr = expr
+	if _, err := p.expect(promTokPunct, ")"); err != nil {
+		return nil, err
+	}
+	if agg.By == nil {
+		if err := parseBy(); err != nil {
+			return nil, err
+		}
+	}
+	return agg, nil
+}
+
+func (p *promParser) parseLabelList() ([]string, error) {
+	if _, err := p.expect(promTokPunct, "("); err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0)
+	for p.peek().text != ")" {
+		t, err := p.expect(promTokIdent, "")
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, t.text)
+		if p.peek().text == "," {
+			p.next()
+		}
+	}
+	p.next()
+	return labels, nil
+}
+
+func (p *promParser) parseCall() (promExpr, error) {
+	call := &promCall{Func: p.next().text}
+	if _, err := p.expect(promTokPunct, "("); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	sel := expr.(*promSelector)
+	if sel.Range == 0 {
+		return nil, fmt.Errorf("%s expects a range vector", call.Func)
+	}
+	call.Arg = sel
+	if _, err := p.expect(promTokPunct, ")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+func (p *promParser) parseSelector() (promExpr, error) {
+	t, err := p.expect(promTokIdent, "")
+	if err != nil {
+		return nil, err
+	}
+	sel := &promSelector{Metric: t.text}
+	if p.peek().text == "{" {
+		p.next()
+		for p.peek().text != "}" {
+			name, err := p.expect(promTokIdent, "")
+			if err != nil {
+				return nil, err
+			}
+			op := p.next()
+			if _, ok := labelMatchOps[op.text]; !ok || op.kind != promTokPunct {
+				return nil, fmt.Errorf("unexpected %q", op.text)
+			}
+			value, err := p.expect(promTokString, "")
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, LabelMatcher{Name: name.text, Op: op.text, Value: value.text})
+			if p.peek().text == "," {
+				p.next()
+			}
+		}
+		p.next()
+	}
+	if p.peek().text == "[" {
+		p.next()
+		d, err := p.expect(promTokDuration, "")
+		if err != nil {
+			return nil, err
+		}
+		if sel.Range, err = ParsePromDuration(d.text); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(promTokPunct, "]"); err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t.kind == promTokIdent && t.text == "offset" {
+		p.next()
+		d, err := p.expect(promTokDuration, "")
+		if err != nil {
+			return nil, err
+		}
+		if sel.Offset, err = ParsePromDuration(d.text); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// promSQL is a translated expression, its columns are labels, ts and value.
+type promSQL struct {
+	sql    string
+	args   []any
+	labels []string
+}
+
+type promTranslator struct {
+	db                 *gorm.DB
+	sqlComment         string
+	start, end         int64
+	step               time.Duration
+	instant            bool
+	cumulativeCounters bool
+	tables             map[string][]string
+	alias              int
+}
+
+func (t *promTranslator) nextAlias() string {
+	t.alias++
+	return fmt.Sprintf("t%d", t.alias)
+}
+
+func (t *promTranslator) tableLabels(table string) ([]string, error) {
+	if labels, ok := t.tables[table]; ok {
+		return labels, nil
+	}
+	labels, err := metricLabelColumns(t.db, table, t.sqlComment)
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, invalidQuery(fmt.Errorf("unknown metric: %s", table))
+	}
+	t.tables[table] = labels
+	return labels, nil
+}
+
+// bucketExpr maps a sample to the first evaluation point at or after it,
+// so evaluation point ts covers the samples in (ts-step, ts].
+func (t *promTranslator) bucketExpr(step time.Duration, offset time.Duration) string {
+	s := int64(step / time.Second)
+	return fmt.Sprintf("%d + CAST(ceil((unix_timestamp(`%s`) + %d - %d)/%d) AS BIGINT)*%d",
+		t.start, metricTimeCol, int64(offset/time.Second), t.start, s, s)
+}
+
+func (t *promTranslator) selectorCond(sel *promSelector, lookback time.Duration) (string, []any) {
+	from := time.Unix(t.start, 0).Add(-sel.Offset - lookback)
+	to := time.Unix(t.end, 0).Add(-sel.Offset)
+	cond := fmt.Sprintf("`%s` > ? and `%s` <= ?", metricTimeCol, metricTimeCol)
+	args := []any{from, to}
+	for _, m := range sel.Matchers {
+		c, arg := m.cond()
+		cond += " and " + c
+		args = append(args, arg)
+	}
+	return cond, args
+}
+
+func (t *promTranslator) checkSelector(sel *promSelector, labels []string) error {
+	if !identRegexp.MatchString(sel.Metric) {
+		return invalidQuery(fmt.Errorf("invalid metric name: %s", sel.Metric))
+	}
+	if sel.Offset%time.Second != 0 {
+		return invalidQuery(fmt.Errorf("invalid offset: %s", sel.Offset))
+	}
+	for _, m := range sel.Matchers {
+		if !containsLabel(labels, m.Name) {
+			return invalidQuery(fmt.Errorf("unknown label %s of %s", m.Name, sel.Metric))
+		}
+	}
+	return nil
+}
+
+func containsLabel(labels []string, l string) bool {
+	for _, o := range labels {
+		if o == l {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *promTranslator) translate(e promExpr) (*promSQL, error) {
+	switch e := e.(type) {
+	case *promSelector:
+		return t.translateSelector(e)
+	case *promCall:
+		return t.translateCall(e)
+	case *promAggregate:
+		return t.translateAggregate(e)
+	}
+	return nil, invalidQuery(fmt.Errorf("unsupported expression %T", e))
+}
+
+// translateSelector takes the latest sample of every series in each step.
+func (t *promTranslator) translateSelector(sel *promSelector) (*promSQL, error) {
+	if sel.Range != 0 {
+		return nil, invalidQuery(errors.New("range vector must be wrapped in rate or increase"))
+	}
+	labels, err := t.tableLabels(sel.Metric)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkSelector(sel, labels); err != nil {
+		return nil, err
+	}
+	step := t.step
+	if t.instant {
+		step = promLookback
+	}
+	bucket := t.bucketExpr(step, sel.Offset)
+	cond, args := t.selectorCond(sel, step)
+	cols := strings.Join(quoteIdents(labels), ", ")
+	partition := strings.Join(append(quoteIdents(labels), bucket), ", ")
+	sql := fmt.Sprintf("select %s, ts, `value` from (select %s, %s AS ts, `value`, "+
+		"row_number() over (partition by %s order by `%s` desc) AS rn from %s.%s where %s)%s where rn = 1 and ts >= %d and ts <= %d",
+		cols, cols, bucket, partition, metricTimeCol, metricsDatabase, sel.Metric, cond, t.nextAlias(), t.start, t.end)
+	return &promSQL{sql: sql, args: args, labels: labels}, nil
+}
+
+// translateCall computes the counter increase per series and step, the sum of the
+// delta samples or, for cumulative counters, of the differences between samples,
+// resets being detected by a value lower than the previous one. It then sums the
+// increases over the range with a rows frame. Series are expected to have samples
+// in every step.
+func (t *promTranslator) translateCall(call *promCall) (*promSQL, error) {
+	sel := call.Arg
+	labels, err := t.tableLabels(sel.Metric)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkSelector(sel, labels); err != nil {
+		return nil, err
+	}
+	step := t.step
+	if t.instant {
+		step = sel.Range
+	}
+	if sel.Range%step != 0 {
+		return nil, invalidQuery(fmt.Errorf("range %s must be a multiple of step %s", sel.Range, step))
+	}
+	k := int64(sel.Range / step)
+	bucket := t.bucketExpr(step, sel.Offset)
+	cols := strings.Join(quoteIdents(labels), ", ")
+
+	var increases string
+	var args []any
+	if !t.cumulativeCounters {
+		var cond string
+		cond, args = t.selectorCond(sel, sel.Range)
+		increases = fmt.Sprintf("select %s, %s AS ts, sum(`value`) AS inc from %s.%s where %s group by %s, %s",
+			cols, bucket, metricsDatabase, sel.Metric, cond, cols, bucket)
+	} else {
+		// look back one more lookback so the first sample in range has a previous one
+		var cond string
+		cond, args = t.selectorCond(sel, sel.Range+promLookback)
+		samples := fmt.Sprintf("select %s, `%s`, `value`, lag(`value`) over (partition by %s order by `%s`) AS prev from %s.%s where %s",
+			cols, metricTimeCol, cols, metricTimeCol, metricsDatabase, sel.Metric, cond)
+		increases = fmt.Sprintf("select %s, %s AS ts, sum(if(prev is null, 0, if(`value` >= prev, `value` - prev, `value`))) AS inc "+
+			"from (%s)%s group by %s, %s", cols, bucket, samples, t.nextAlias(), cols, bucket)
+	}
+
+	value := fmt.Sprintf("sum(inc) over (partition by %s order by ts rows between %d preceding and current row)", cols, k-1)
+	if call.Func == "rate" {
+		value = fmt.Sprintf("%s/%d", value, int64(sel.Range/time.Second))
+	}
+	sql := fmt.Sprintf("select %s, ts, `value` from (select %s, ts, %s AS `value` from (%s)%s)%s where ts >= %d and ts <= %d",
+		cols, cols, value, increases, t.nextAlias(), t.nextAlias(), t.start, t.end)
+	return &promSQL{sql: sql, args: args, labels: labels}, nil
+}
+
+func (t *promTranslator) translateAggregate(agg *promAggregate) (*promSQL, error) {
+	inner, err := t.translate(agg.Expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range agg.By {
+		if !containsLabel(inner.labels, l) {
+			return nil, invalidQuery(fmt.Errorf("unknown label in by: %s", l))
+		}
+	}
+	by := quoteIdents(agg.By)
+	if agg.Op == "topk" {
+		cols := strings.Join(quoteIdents(inner.labels), ", ")
+		partition := strings.Join(append([]string{"ts"}, by...), ", ")
+		sql := fmt.Sprintf("select %s, ts, `value` from (select %s, ts, `value`, "+
+			"row_number() over (partition by %s order by `value` desc) AS rn from (%s)%s)%s where rn <= %d",
+			cols, cols, partition, inner.sql, t.nextAlias(), t.nextAlias(), agg.Param)
+		return &promSQL{sql: sql, args: inner.args, labels: inner.labels}, nil
+	}
+	proj := strings.Join(append(by, "ts", fmt.Sprintf("%s(`value`) AS `value`", agg.Op)), ", ")
+	group := strings.Join(append(by, "ts"), ", ")
+	sql := fmt.Sprintf("select %s from (%s)%s group by %s", proj, inner.sql, t.nextAlias(), group)
+	return &promSQL{sql: sql, args: inner.args, labels: agg.By}, nil
+}
+
+// metricLabelColumns returns the columns of a system_metrics table besides collecttime and value.
+func metricLabelColumns(db *gorm.DB, table, sqlComment string) ([]string, error) {
+	columns := make([]string, 0)
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("information_schema.columns").
+		Select("column_name").Where("table_schema = ? and table_name = ?", metricsDatabase, table).
+		Order("ordinal_position").Pluck("column_name", &columns).Error; err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if c != metricTimeCol && c != metricValueCol {
+			labels = append(labels, c)
+		}
+	}
+	return labels, nil
+}
+
+type PromQuery struct {
+	Query string
+	// Start, End and Step are the evaluation points of a range query,
+	// an instant query is evaluated at End only.
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+	// CumulativeCounters reads counter samples as running totals. By default
+	// they are the increase since the previous collection, as system_metrics
+	// stores them.
+	CumulativeCounters bool
+}
+
+type VectorSample struct {
+	Labels    map[string]string `json:"labels"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+}
+
+func (q *PromQuery) translate(db *gorm.DB, sqlComment string, instant bool, expr promExpr) (*promSQL, error) {
+	t := &promTranslator{
+		db:                 db,
+		sqlComment:         sqlComment,
+		end:                q.End.Unix(),
+		instant:            instant,
+		cumulativeCounters: q.CumulativeCounters,
+		tables:             make(map[string][]string),
+	}
+	if instant {
+		t.start = t.end
+	} else {
+		if q.Step < time.Second || q.Step%time.Second != 0 {
+			return nil, invalidQuery(fmt.Errorf("invalid step: %s", q.Step))
+		}
+		if q.Start.IsZero() || q.Start.After(q.End) {
+			return nil, invalidQuery(errors.New("Invalid time range"))
+		}
+		t.start, t.step = q.Start.Unix(), q.Step
+		// evaluation points are start + n*step
+		t.end = t.start + (t.end-t.start)/int64(q.Step/time.Second)*int64(q.Step/time.Second)
+	}
+	return t.translate(expr)
+}
+
+func (q *PromQuery) run(db *gorm.DB, sqlComment string, instant bool) ([]MetricSeries, error) {
+	expr, err := parsePromQL(q.Query)
+	if err != nil {
+		return nil, invalidQuery(err)
+	}
+	return q.runExpr(db, sqlComment, instant, expr)
+}
+
+func (q *PromQuery) runExpr(db *gorm.DB, sqlComment string, instant bool, expr promExpr) ([]MetricSeries, error) {
+	s, err := q.translate(db, sqlComment, instant, expr)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Raw(sqlCommentPrefix(sqlComment)+s.sql, s.args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricSeries(rows, s.labels)
+}
+
+// QueryPromQLRange evaluates q.Query at every step in [q.Start, q.End] and returns a matrix.
+func QueryPromQLRange(db *gorm.DB, q PromQuery, sqlComment string) ([]MetricSeries, error) {
+	return q.run(db, sqlComment, false)
+}
+
+// QueryPromQL evaluates q.Query at q.End and returns a vector.
+func QueryPromQL(db *gorm.DB, q PromQuery, sqlComment string) ([]VectorSample, error) {
+	series, err := q.run(db, sqlComment, true)
+	if err != nil {
+		return nil, err
+	}
+	vector := make([]VectorSample, 0, len(series))
+	for _, s := range series {
+		for _, p := range s.Points {
+			if !p.Null {
+				vector = append(vector, VectorSample{Labels: s.Labels, Timestamp: p.Timestamp, Value: p.Value})
+			}
+		}
+	}
+	return vector, nil
+}
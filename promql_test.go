@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePromQL(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  promExpr
+		err   string
+	}{
+		{query: "test_metric", want: &promSelector{Metric: "test_metric"}},
+		{query: `test_metric{node="cn1", pod!="p1", type=~"select|insert", account!~"sys.*"}`,
+			want: &promSelector{Metric: "test_metric", Matchers: []LabelMatcher{
+				{Name: "node", Op: "=", Value: "cn1"},
+				{Name: "pod", Op: "!=", Value: "p1"},
+				{Name: "type", Op: "=~", Value: "select|insert"},
+				{Name: "account", Op: "!~", Value: "sys.*"},
+			}}},
+		{query: "test_metric offset 5m", want: &promSelector{Metric: "test_metric", Offset: 5 * time.Minute}},
+		{query: "rate(test_metric[1h30m] offset 1d)",
+			want: &promCall{Func: "rate", Arg: &promSelector{Metric: "test_metric", Range: 90 * time.Minute, Offset: 24 * time.Hour}}},
+		{query: `increase(test_metric{node="cn1"}[5m])`,
+			want: &promCall{Func: "increase", Arg: &promSelector{Metric: "test_metric",
+				Matchers: []LabelMatcher{{Name: "node", Op: "=", Value: "cn1"}}, Range: 5 * time.Minute}}},
+		{query: "sum by (node, type) (rate(test_metric[5m]))",
+			want: &promAggregate{Op: "sum", By: []string{"node", "type"},
+				Expr: &promCall{Func: "rate", Arg: &promSelector{Metric: "test_metric", Range: 5 * time.Minute}}}},
+		{query: "sum(test_metric) by (node)",
+			want: &promAggregate{Op: "sum", By: []string{"node"}, Expr: &promSelector{Metric: "test_metric"}}},
+		{query: "avg(test_metric)", want: &promAggregate{Op: "avg", Expr: &promSelector{Metric: "test_metric"}}},
+		{query: "topk(3, sum by (node) (test_metric))",
+			want: &promAggregate{Op: "topk", Param: 3,
+				Expr: &promAggregate{Op: "sum", By: []string{"node"}, Expr: &promSelector{Metric: "test_metric"}}}},
+		{query: "rate(test_metric[5m]", err: "unexpected end of query"},
+		{query: "rate(test_metric)", err: "rate expects a range vector"},
+		{query: `test_metric{node=="cn1"}`, err: `unexpected "="`},
+		{query: "test_metric{node=cn1}", err: `unexpected "cn1"`},
+		{query: "test_metric[5x]", err: "5x"},
+		{query: "sum without (node) (test_metric)", err: "without is not supported"},
+		{query: "topk(0, test_metric)", err: "invalid topk parameter: 0"},
+		{query: "topk(test_metric)", err: `unexpected "test_metric"`},
+		{query: "sum by (node (test_metric)", err: `unexpected "("`},
+		{query: "test_metric test_metric", err: `unexpected "test_metric"`},
+		{query: "", err: "unexpected"},
+	} {
+		got, err := parsePromQL(tc.query)
+		switch {
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: got %v, want error %q", tc.query, err, tc.err)
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: %v", tc.query, err)
+		case tc.err == "" && !reflect.DeepEqual(got, tc.want):
+			t.Errorf("%q: got %#v, want %#v", tc.query, got, tc.want)
+		}
+	}
+}
+
+// startCounterMO serves one counter series of cn1 sampled every 30s around fixtureStart,
+// with a reset at +90s when the samples are running totals.
+func startCounterMO(t *testing.T) *FakeMO {
+	f := StartFakeMO(t, FakeMOOptions{})
+	if err := f.CreateMetricTable("test_counter", "node"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []struct {
+		offset time.Duration
+		value  float64
+	}{
+		{-60 * time.Second, 10}, {0, 10}, {30 * time.Second, 20}, {60 * time.Second, 30},
+		{90 * time.Second, 5}, {120 * time.Second, 15}, {150 * time.Second, 25}, {180 * time.Second, 35},
+	} {
+		if err := f.Insert(metricsDatabase+".test_counter", map[string]any{
+			metricTimeCol: fixtureStart.Add(s.offset), metricValueCol: s.value, "node": "cn1",
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestPromQLCounters(t *testing.T) {
+	f := startCounterMO(t)
+	for _, tc := range []struct {
+		query      string
+		cumulative bool
+		want       string
+	}{
+		// deltas are summed per step, the sample at -60s is in the step before the first
+		{"increase(test_counter[1m])", false, "[10 50 20 60]"},
+		{"increase(test_counter[2m])", false, "[20 60 70 80]"},
+		// running totals are differenced, 30 -> 5 at +90s is a reset counted as 5
+		{"increase(test_counter[1m])", true, "[0 20 15 20]"},
+		{"increase(test_counter[2m])", true, "[0 20 35 35]"},
+		{"rate(test_counter[1m])", true, "[0 0.3333333333333333 0.25 0.3333333333333333]"},
+		{"sum by (node) (rate(test_counter[2m]))", true, "[0 0.16666666666666666 0.2916666666666667 0.2916666666666667]"},
+	} {
+		series, err := QueryPromQLRange(f.DB(), PromQuery{
+			Query:              tc.query,
+			Start:              fixtureStart,
+			End:                fixtureStart.Add(3 * time.Minute),
+			Step:               time.Minute,
+			CumulativeCounters: tc.cumulative,
+		}, "")
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if len(series) != 1 {
+			t.Fatalf("%s: got %v, want one series", tc.query, series)
+		}
+		var values []float64
+		for _, p := range series[0].Points {
+			values = append(values, p.Value)
+		}
+		if got := fmt.Sprint(values); got != tc.want {
+			t.Errorf("%s cumulative=%v: got %s, want %s", tc.query, tc.cumulative, got, tc.want)
+		}
+	}
+}
+
+func TestPromQLInvalidQuery(t *testing.T) {
+	f := startCounterMO(t)
+	for _, tc := range []struct {
+		query string
+		step  time.Duration
+		err   string
+	}{
+		{"rate(test_counter[90s])", time.Minute, "range 1m30s must be a multiple of step 1m0s"},
+		{"increase(test_counter[5m])", 2 * time.Minute, "range 5m0s must be a multiple of step 2m0s"},
+		{"rate(test_counter[1m])", 1500 * time.Millisecond, "invalid step"},
+		{"test_counter[5m]", time.Minute, "range vector must be wrapped in rate or increase"},
+		{`test_counter{pod="p1"}`, time.Minute, "unknown label pod of test_counter"},
+		{"sum by (pod) (test_counter)", time.Minute, "unknown label in by: pod"},
+		{"no_metric", time.Minute, "unknown metric: no_metric"},
+		{"rate(test_counter[1m]", time.Minute, "unexpected end of query"},
+	} {
+		_, err := QueryPromQLRange(f.DB(), PromQuery{
+			Query: tc.query,
+			Start: fixtureStart,
+			End:   fixtureStart.Add(time.Hour),
+			Step:  tc.step,
+		}, "")
+		if !errors.Is(err, ErrInvalidParameter) || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got %v, want an invalid parameter %q", tc.query, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+const (
+	defaultProxyHeaderWait   = 2 * time.Second
+	defaultProxyBackendDelay = 10 * time.Second
+)
+
+// ProxyHeader is the PROXY protocol header a connection of the ProxyServer came with.
+// Version is 0 when the client sent none, Err is set when the header did not parse.
+type ProxyHeader struct {
+	At          time.Time
+	Remote      string
+	Version     byte
+	Command     string
+	Source      string
+	Destination string
+	TLVs        []proxyproto.TLV
+	Err         error
+}
+
+// SourceIP is the client IP the header carries, empty without a header.
+func (h ProxyHeader) SourceIP() string {
+	host, _, err := net.SplitHostPort(h.Source)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+func (h ProxyHeader) String() string {
+	if h.Err != nil {
+		return fmt.Sprintf("%s: invalid header: %v", h.Remote, h.Err)
+	}
+	if h.Version == 0 {
+		return fmt.Sprintf("%s: no header", h.Remote)
+	}
+	s := fmt.Sprintf("%s: v%d %s %s -> %s", h.Remote, h.Version, h.Command, h.Source, h.Destination)
+	for _, tlv := range h.TLVs {
+		s += fmt.Sprintf(" tlv(0x%02x)=%q", byte(tlv.Type), tlv.Value)
+	}
+	return s
+}
+
+type ProxyServerOptions struct {
+	// Backend is the MO, real or fake, the connections are forwarded to. Without it
+	// the connection is closed once its header is recorded.
+	Backend string
+	// ForwardHeader writes the header again to the backend, for a backend which
+	// expects it, otherwise it is stripped.
+	ForwardHeader bool
+	// HeaderTimeout is how long to wait for a header before forwarding without one,
+	// a mysql client without PROXY protocol waits for the server to speak first.
+	HeaderTimeout time.Duration
+	// OnHeader is called with every header as it arrives.
+	OnHeader func(ProxyHeader)
+}
+
+// ProxyServer is a localhost stand-in for the proxy in front of MO: it parses the
+// PPv1/PPv2 header of every connection, records it, and forwards the rest of the
+// connection to the backend. It checks what Config.Dial sends.
+type ProxyServer struct {
+	Addr string
+
+	t    testing.TB
+	opts ProxyServerOptions
+	ln   net.Listener
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	headers []ProxyHeader
+	conns   map[net.Conn]struct{}
+	closed  bool
+}
+
+// StartProxyServer listens on a free port of localhost, the server is closed with the test.
+func StartProxyServer(t testing.TB, opts ProxyServerOptions) *ProxyServer {
+	t.Helper()
+	if opts.HeaderTimeout == 0 {
+		opts.HeaderTimeout = defaultProxyHeaderWait
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{Addr: ln.Addr().String(), t: t, opts: opts, ln: ln, conns: map[net.Conn]struct{}{}}
+	p.wg.Add(1)
+	go p.serve()
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Headers returns the headers recorded so far, in the order the connections came in.
+func (p *ProxyServer) Headers() []ProxyHeader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ProxyHeader(nil), p.headers...)
+}
+
+// WaitHeaders waits until n headers are recorded and returns them, it fails the test
+// after a few seconds.
+func (p *ProxyServer) WaitHeaders(n int) []ProxyHeader {
+	p.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if headers := p.Headers(); len(headers) >= n {
+			return headers
+		} else if time.Now().After(deadline) {
+			p.t.Fatalf("got %d headers, want %d", len(headers), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Close stops listening and closes the open connections, once it returns the header of
+// every connection that came in is recorded.
+func (p *ProxyServer) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.ln.Close()
+	p.wg.Wait()
+}
+
+func (p *ProxyServer) serve() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.t.Logf("proxy server accept: %v", err)
+			}
+			return
+		}
+		if !p.track(conn) {
+			conn.Close()
+			return
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.untrack(conn)
+			p.handle(conn)
+		}()
+	}
+}
+
+func (p *ProxyServer) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *ProxyServer) untrack(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+	conn.Close()
+}
+
+func (p *ProxyServer) record(h ProxyHeader) {
+	p.mu.Lock()
+	p.headers = append(p.headers, h)
+	p.mu.Unlock()
+	if p.opts.OnHeader != nil {
+		p.opts.OnHeader(h)
+	}
+}
+
+func (p *ProxyServer) handle(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	h := ProxyHeader{At: time.Now(), Remote: conn.RemoteAddr().String()}
+	// a deadline rather than proxyproto.ReadTimeout, which leaves a reader of br behind
+	conn.SetReadDeadline(time.Now().Add(p.opts.HeaderTimeout))
+	header, err := proxyproto.Read(br)
+	conn.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() && br.Buffered() == 0 {
+		err = proxyproto.ErrNoProxyProtocol
+	}
+	switch {
+	case err == nil:
+		h.Version = header.Version
+		h.Command = proxyCommandName(header.Command)
+		if header.SourceAddr != nil {
+			h.Source = header.SourceAddr.String()
+		}
+		if header.DestinationAddr != nil {
+			h.Destination = header.DestinationAddr.String()
+		}
+		if h.TLVs, err = header.TLVs(); err != nil {
+			h.Err = err
+		}
+	case errors.Is(err, proxyproto.ErrNoProxyProtocol):
+		header = nil
+	default:
+		h.Err = err
+	}
+	p.record(h)
+	if h.Err != nil || p.opts.Backend == "" {
+		return
+	}
+
+	backend, err := net.DialTimeout("tcp", p.opts.Backend, defaultProxyBackendDelay)
+	if err != nil {
+		p.t.Logf("proxy server dial backend %s: %v", p.opts.Backend, err)
+		return
+	}
+	if !p.track(backend) {
+		backend.Close()
+		return
+	}
+	defer p.untrack(backend)
+	if header != nil && p.opts.ForwardHeader {
+		if _, err := header.WriteTo(backend); err != nil {
+			p.t.Logf("proxy server forward header: %v", err)
+			return
+		}
+	}
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst net.Conn, src io.Reader) {
+		io.Copy(dst, src)
+		// unblock the other direction
+		dst.Close()
+		done <- struct{}{}
+	}
+	// br holds what the client sent after the header
+	go pipe(backend, br)
+	go pipe(conn, backend)
+	<-done
+	<-done
+}
+
+func proxyCommandName(c proxyproto.ProtocolVersionAndCommand) string {
+	switch {
+	case c.IsProxy():
+		return "PROXY"
+	case c.IsLocal():
+		return "LOCAL"
+	}
+	return "UNKNOWN"
+}
+
+func TestDialClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		clientIP    string
+		destination string
+	}{
+		{"ipv4", "10.1.2.3", "127.0.0.1:0"},
+		{"ipv6", "2001:db8::1", "[::1]:0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := StartProxyServer(t, ProxyServerOptions{})
+			cfg := Config{PPV2Enabled: true, ClientIP: tc.clientIP}
+			conn, err := cfg.Dial(context.Background(), p.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			h := p.WaitHeaders(1)[0]
+			if h.Err != nil || h.Version != 2 || h.Command != "PROXY" {
+				t.Fatalf("got %s", h)
+			}
+			if got := h.SourceIP(); !net.ParseIP(got).Equal(net.ParseIP(tc.clientIP)) {
+				t.Errorf("source IP %q, want %q", got, tc.clientIP)
+			}
+			if h.Destination != tc.destination {
+				t.Errorf("destination %q, want %q", h.Destination, tc.destination)
+			}
+		})
+	}
+}
+
+func TestDialInvalidClientIP(t *testing.T) {
+	for _, clientIP := range []string{"", "10.1.2", "localhost"} {
+		t.Run(fmt.Sprintf("%q", clientIP), func(t *testing.T) {
+			p := StartProxyServer(t, ProxyServerOptions{HeaderTimeout: 100 * time.Millisecond})
+			cfg := Config{PPV2Enabled: true, ClientIP: clientIP}
+			conn, err := cfg.Dial(context.Background(), p.Addr)
+			if err == nil {
+				conn.Close()
+				t.Fatal("dialed without a valid client IP")
+			}
+			if !strings.Contains(err.Error(), "invalid client IP") {
+				t.Errorf("got %v, want invalid client IP", err)
+			}
+			// the headers of every connection are recorded once Close returns
+			p.Close()
+			if headers := p.Headers(); len(headers) > 0 {
+				t.Errorf("connected before checking the client IP: %s", headers[0])
+			}
+		})
+	}
+}
+
+func TestProxyServerForwards(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	p := StartProxyServer(t, ProxyServerOptions{Backend: backend.Addr().String()})
+	cfg := Config{PPV2Enabled: true, ClientIP: "10.1.2.3"}
+	conn, err := cfg.Dial(context.Background(), p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the backend echoes, so the header was stripped if only the ping comes back
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("backend got %q, want ping", got)
+	}
+}
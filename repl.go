@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterh/liner"
+	"gorm.io/gorm"
+)
+
+const (
+	replPrompt         = "mo> "
+	replContinuePrompt = "  -> "
+	replHistoryFile    = ".gorm_demo_history"
+)
+
+// replTags are the comments a statement can be tagged with, see \tag.
+var replTags = map[string]string{
+	"user":    UserComment,
+	"nonuser": NonUserComment,
+	"none":    "",
+}
+
+// queryKeywords start the statements that return rows, the others are executed.
+var queryKeywords = map[string]bool{
+	"select":   true,
+	"show":     true,
+	"desc":     true,
+	"describe": true,
+	"explain":  true,
+	"with":     true,
+	"values":   true,
+	"table":    true,
+}
+
+var sqlKeywords = []string{
+	"SELECT", "FROM", "WHERE", "GROUP BY", "ORDER BY", "LIMIT", "OFFSET", "HAVING", "JOIN", "LEFT JOIN",
+	"ON", "AND", "OR", "NOT", "IN", "BETWEEN", "LIKE", "IS NULL", "AS", "DISTINCT", "COUNT", "SUM",
+	"AVG", "MIN", "MAX", "INSERT INTO", "VALUES", "UPDATE", "SET", "DELETE", "SHOW", "DATABASES",
+	"TABLES", "DESC", "EXPLAIN", "USE", "BEGIN", "COMMIT", "ROLLBACK",
+}
+
+// ResultSet is the result of a statement typed in the REPL.
+type ResultSet struct {
+	Columns []string
+	Rows    [][]any
+}
+
+// MarshalJSON writes the rows as objects keeping the column order.
+func (r *ResultSet) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, row := range r.Rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('{')
+		for j, cell := range row {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(r.Columns[j])
+			value, err := json.Marshal(cell)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// Repl runs the statements typed by the user on one pinned connection,
+// so USE, SET and transactions carry over between statements.
+type Repl struct {
+	conn   *sql.Conn
+	out    io.Writer
+	render RenderOptions
+	// tag is prepended to every statement, one of the values of replTags.
+	tag    string
+	timing bool
+	// showID prints the statement_id of every statement, read with last_query_id().
+	showID bool
+
+	databases []string
+	tables    map[string][]string
+	columns   []string
+}
+
+// NewRepl pins a connection of db for the session.
+func NewRepl(ctx context.Context, db *gorm.DB, out io.Writer, render RenderOptions) (*Repl, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Repl{
+		conn:   conn,
+		out:    out,
+		render: render,
+		tag:    NonUserComment,
+		showID: true,
+		tables: make(map[string][]string),
+	}, nil
+}
+
+func (r *Repl) Close() error {
+	return r.conn.Close()
+}
+
+// LoadCompletions reads the database, table and column names from the information schema.
+func (r *Repl) LoadCompletions(ctx context.Context) error {
+	rows, err := r.conn.QueryContext(ctx, NonUserComment+" select table_schema, table_name from information_schema.tables")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tables := make(map[string][]string)
+	for rows.Next() {
+		var schema, name string
+		if err := rows.Scan(&schema, &name); err != nil {
+			return err
+		}
+		tables[schema] = append(tables[schema], name)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	colRows, err := r.conn.QueryContext(ctx, NonUserComment+" select distinct column_name from information_schema.columns")
+	if err != nil {
+		return err
+	}
+	defer colRows.Close()
+	var columns []string
+	for colRows.Next() {
+		var name string
+		if err := colRows.Scan(&name); err != nil {
+			return err
+		}
+		columns = append(columns, name)
+	}
+	if err := colRows.Err(); err != nil {
+		return err
+	}
+
+	databases := make([]string, 0, len(tables))
+	for db := range tables {
+		databases = append(databases, db)
+	}
+	sort.Strings(databases)
+	sort.Strings(columns)
+	r.databases, r.tables, r.columns = databases, tables, columns
+	return nil
+}
+
+// complete suggests keywords, databases, tables and columns for the word before the cursor,
+// db.prefix only suggests the tables of db.
+func (r *Repl) complete(line string, pos int) (string, []string, string) {
+	head, tail := line[:pos], line[pos:]
+	start := strings.LastIndexAny(head, " \t\n(,=`") + 1
+	word := head[start:]
+	head = head[:start]
+	if word == "" {
+		return head, nil, tail
+	}
+
+	var candidates []string
+	if db, prefix, ok := strings.Cut(word, "."); ok {
+		for _, t := range r.tables[db] {
+			if strings.HasPrefix(t, prefix) {
+				candidates = append(candidates, db+"."+t)
+			}
+		}
+		return head, candidates, tail
+	}
+	seen := make(map[string]bool)
+	add := func(c string) {
+		if !seen[c] {
+			seen[c] = true
+			candidates = append(candidates, c)
+		}
+	}
+	upper := strings.ToUpper(word)
+	for _, k := range sqlKeywords {
+		if strings.HasPrefix(k, upper) {
+			add(k)
+		}
+	}
+	for _, db := range r.databases {
+		if strings.HasPrefix(db, word) {
+			add(db)
+		}
+		for _, t := range r.tables[db] {
+			if strings.HasPrefix(t, word) {
+				add(t)
+			}
+		}
+	}
+	for _, c := range r.columns {
+		if strings.HasPrefix(c, word) {
+			add(c)
+		}
+	}
+	return head, candidates, tail
+}
+
+// Exec runs one statement and prints its result.
+func (r *Repl) Exec(ctx context.Context, stmt string) error {
+	stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+	if stmt == "" {
+		return nil
+	}
+	query := stmt
+	if r.tag != "" {
+		query = r.tag + " " + stmt
+	}
+
+	// ctrl-c cancels the running statement only
+	stmtCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	begin := time.Now()
+	summary, err := r.run(stmtCtx, stmt, query)
+	elapsed := time.Since(begin)
+	if err != nil {
+		return err
+	}
+	if r.timing {
+		summary += fmt.Sprintf(" (%s)", humanDuration(elapsed))
+	}
+	fmt.Fprintln(r.out, summary)
+	if r.showID {
+		var id sql.NullString
+		if err := r.conn.QueryRowContext(ctx, NonUserComment+" select last_query_id()").Scan(&id); err == nil && id.Valid {
+			fmt.Fprintf(r.out, "statement_id: %s\n", id.String)
+		}
+	}
+	return nil
+}
+
+func (r *Repl) run(ctx context.Context, stmt, query string) (string, error) {
+	rs, affected, err := runStatement(ctx, r.conn, stmt, query)
+	if err != nil {
+		return "", err
+	}
+	if rs == nil {
+		return fmt.Sprintf("%d rows affected", affected), nil
+	}
+	if err := Render(r.out, rs, r.render); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d rows in set", len(rs.Rows)), nil
+}
+
+// runStatement runs query, which is stmt with its comment, on conn. The statements
+// starting with one of queryKeywords return their rows, the others the affected rows.
+func runStatement(ctx context.Context, conn *sql.Conn, stmt, query string) (*ResultSet, int64, error) {
+	first := ""
+	if fields := strings.Fields(strings.TrimLeft(stmt, "( \t\n")); len(fields) > 0 {
+		first = strings.ToLower(fields[0])
+	}
+	if !queryKeywords[first] {
+		res, err := conn.ExecContext(ctx, query)
+		if err != nil {
+			return nil, 0, err
+		}
+		affected, _ := res.RowsAffected()
+		return nil, affected, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, 0, err
+	}
+	rs := &ResultSet{Columns: columns}
+	for rows.Next() {
+		cells := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range cells {
+			dest[i] = &cells[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, 0, err
+		}
+		row := make([]any, len(columns))
+		for i, c := range cells {
+			if c.Valid {
+				row[i] = c.String
+			}
+		}
+		rs.Rows = append(rs.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return rs, 0, nil
+}
+
+// splitStatements splits s at the semicolons outside of quotes and comments,
+// dropping the empty statements.
+func splitStatements(s string) []string {
+	var (
+		stmts []string
+		start int
+		quote byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '#' || c == '-' && strings.HasPrefix(s[i:], "-- "):
+			if end := strings.IndexByte(s[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(s)
+			}
+		case c == '/' && strings.HasPrefix(s[i:], "/*"):
+			if end := strings.Index(s[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(s)
+			}
+		case c == ';':
+			stmts = append(stmts, s[start:i])
+			start = i + 1
+		}
+	}
+	stmts = append(stmts, s[start:])
+
+	out := stmts[:0]
+	for _, stmt := range stmts {
+		if strings.TrimSpace(stmt) != "" {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// meta handles the backslash commands, quit reports \q.
+func (r *Repl) meta(ctx context.Context, line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+	onOff := func(v *bool) error {
+		switch arg {
+		case "":
+			*v = !*v
+		case "on":
+			*v = true
+		case "off":
+			*v = false
+		default:
+			return fmt.Errorf("expect on or off: %s", arg)
+		}
+		return nil
+	}
+	switch fields[0] {
+	case `\q`, `\quit`, `\exit`:
+		return true, nil
+	case `\timing`:
+		err = onOff(&r.timing)
+		fmt.Fprintf(r.out, "timing is %v\n", r.timing)
+	case `\id`:
+		err = onOff(&r.showID)
+		fmt.Fprintf(r.out, "statement_id is %v\n", r.showID)
+	case `\tag`:
+		tag, ok := replTags[arg]
+		if !ok {
+			return false, fmt.Errorf("expect user, nonuser or none: %s", arg)
+		}
+		r.tag = tag
+		fmt.Fprintf(r.out, "tagging statements with %q\n", tag)
+	case `\output`:
+		if !OutputFormat(arg).valid() {
+			return false, fmt.Errorf("expect table, json, ndjson, csv, yaml or markdown: %s", arg)
+		}
+		r.render.Format = OutputFormat(arg)
+	case `\refresh`:
+		err = r.LoadCompletions(ctx)
+	case `\h`, `\help`, `\?`:
+		fmt.Fprint(r.out, `\timing [on|off]        print the elapsed time of every statement
+\id [on|off]            print the statement_id of every statement
+\tag user|nonuser|none  tag the statements with /* cloud_user */, /* cloud_nonuser */ or nothing
+\output <format>        table, json, ndjson, csv, yaml or markdown
+\refresh                reload the names for the completion
+\q                      quit
+`)
+	default:
+		err = fmt.Errorf("unknown command %s, try \\help", fields[0])
+	}
+	return false, err
+}
+
+// Run reads statements until \q or EOF, a statement ends with ; and may span lines.
+func (r *Repl) Run(ctx context.Context) error {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+	line.SetWordCompleter(r.complete)
+
+	historyPath := replHistoryFile
+	if home, err := os.UserHomeDir(); err == nil {
+		historyPath = filepath.Join(home, replHistoryFile)
+	}
+	if f, err := os.Open(historyPath); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+	defer func() {
+		if f, err := os.Create(historyPath); err == nil {
+			line.WriteHistory(f)
+			f.Close()
+		}
+	}()
+
+	var buf strings.Builder
+	for {
+		prompt := replPrompt
+		if buf.Len() > 0 {
+			prompt = replContinuePrompt
+		}
+		input, err := line.Prompt(prompt)
+		if errors.Is(err, liner.ErrPromptAborted) {
+			buf.Reset()
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(r.out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		trimmed := strings.TrimSpace(input)
+		if buf.Len() == 0 && strings.HasPrefix(trimmed, `\`) {
+			line.AppendHistory(trimmed)
+			quit, err := r.meta(ctx, trimmed)
+			if err != nil {
+				fmt.Fprintln(r.out, "ERROR:", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(input)
+		if !strings.HasSuffix(trimmed, ";") {
+			continue
+		}
+
+		stmt := buf.String()
+		buf.Reset()
+		line.AppendHistory(strings.Join(strings.Fields(stmt), " "))
+		if err := r.Exec(ctx, stmt); err != nil {
+			fmt.Fprintln(r.out, "ERROR:", err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string
+	}{
+		{"select 1; select 2;", []string{"select 1", " select 2"}},
+		{"select 1", []string{"select 1"}},
+		{";; ;", nil},
+		{`select 'a;b', "c;d", ` + "`e;f`" + `; select 2`, []string{`select 'a;b', "c;d", ` + "`e;f`", " select 2"}},
+		{`select 'it\'s;' ; select 'x'';y'`, []string{`select 'it\'s;' `, ` select 'x'';y'`}},
+		{"select 1 -- a; b\n; select 2 # c; d\n", []string{"select 1 -- a; b\n", " select 2 # c; d\n"}},
+		{"select /* a; b */ 1; select 2-1", []string{"select /* a; b */ 1", " select 2-1"}},
+		{"select 'unterminated; select 2", []string{"select 'unterminated; select 2"}},
+	} {
+		if got := splitStatements(tc.in); !reflect.DeepEqual(got, tc.want) && (len(got) != 0 || len(tc.want) != 0) {
+			t.Errorf("splitStatements(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestReplOutputFormat(t *testing.T) {
+	r := &Repl{render: RenderOptions{Format: OutputTable}, out: io.Discard}
+	if _, err := r.meta(context.Background(), `\output json`); err != nil || r.render.Format != OutputJSON {
+		t.Fatalf("got %s %v, want json", r.render.Format, err)
+	}
+	if _, err := r.meta(context.Background(), `\output xml`); err == nil || r.render.Format != OutputJSON {
+		t.Fatalf("got %s %v, want an error and json kept", r.render.Format, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotRunning       = errors.New("statement or session is not running")
+)
+
+// RunningStatement is a statement still in Running status.
+type RunningStatement struct {
+	StatementId   string        `json:"statement_id"`
+	SessionId     string        `json:"session_id"`
+	TransactionId string        `json:"transaction_id"`
+	Account       string        `json:"account"`
+	User          string        `json:"user"`
+	Host          string        `json:"host"`
+	Database      string        `json:"database"`
+	Statement     string        `json:"statement"`
+	NodeUuid      string        `json:"node_uuid"`
+	NodeType      string        `json:"node_type"`
+	RequestAt     time.Time     `json:"request_at"`
+	Elapsed       time.Duration `json:"elapsed"`
+}
+
+// processInfo is one row of MO's processlist() table function.
+type processInfo struct {
+	ConnId      uint64
+	SessionId   string
+	Account     string
+	StatementId string
+}
+
+const runningProj = "statement_id, session_id, transaction_id, account, `user`, host, `database`, statement, node_uuid, node_type, request_at, " +
+	"TIMESTAMPDIFF(MICROSECOND, request_at, now())*1000 AS elapsed"
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// SelectRunning lists the statements of p.Account that are running right now, the oldest first.
+func (p StatementInfo) SelectRunning(db *gorm.DB, sqlComment string) ([]RunningStatement, error) {
+	if p.Account == "" {
+		return nil, errors.New("Invalid Params")
+	}
+	records := make([]RunningStatement, 0)
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).Select(runningProj).
+		Where("status = ? and account = ?", runningStatus, p.Account).
+		Order("request_at").Scan(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// KillStatement cancels the running statement p.StatementId, it keeps the connection.
+// The caller is the account db is connected as, only sys and the ob account may kill
+// other accounts' statements.
+func (p StatementInfo) KillStatement(db *gorm.DB, sqlComment string) error {
+	if !uuidRegexp.MatchString(p.StatementId) {
+		return errors.New("Invalid Params")
+	}
+	proc, err := lookupProcess(db, sqlComment, "statement_id = ?", p.StatementId)
+	if err != nil {
+		return err
+	}
+	return db.Exec(fmt.Sprintf("%skill query %d \"%s\"", sqlCommentPrefix(sqlComment), proc.ConnId, p.StatementId)).Error
+}
+
+// KillSession closes the connection of session p.SessionId, see KillStatement for permissions.
+func (p StatementInfo) KillSession(db *gorm.DB, sqlComment string) error {
+	if !uuidRegexp.MatchString(p.SessionId) {
+		return errors.New("Invalid Params")
+	}
+	proc, err := lookupProcess(db, sqlComment, "session_id = ?", p.SessionId)
+	if err != nil {
+		return err
+	}
+	return db.Exec(fmt.Sprintf("%skill connection %d", sqlCommentPrefix(sqlComment), proc.ConnId)).Error
+}
+
+// currentAccount is the account db is connected as, which is what MO checks the kill
+// against too, unlike an account the caller could name.
+func currentAccount(db *gorm.DB, sqlComment string) (string, error) {
+	var account string
+	if err := db.Raw(sqlCommentPrefix(sqlComment) + "select current_account_name()").Scan(&account).Error; err != nil {
+		return "", err
+	}
+	if account == "" {
+		return "", errors.New("the account of the connection is unknown")
+	}
+	return account, nil
+}
+
+func lookupProcess(db *gorm.DB, sqlComment string, cond string, args ...any) (*processInfo, error) {
+	caller, err := currentAccount(db, sqlComment)
+	if err != nil {
+		return nil, err
+	}
+	var proc processInfo
+	err = db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table("processlist() a").
+		Select("conn_id, session_id, account, statement_id").Where(cond, args...).Take(&proc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotRunning
+	} else if err != nil {
+		return nil, err
+	}
+	if !canManageAccount(caller, proc.Account) {
+		return nil, ErrPermissionDenied
+	}
+	return &proc, nil
+}
+
+// canManageAccount reports whether caller may operate on the work of target.
+func canManageAccount(caller, target string) bool {
+	return caller == target || caller == sysAccount || caller == obAccount
+}
+
+func sqlCommentPrefix(sqlComment string) string {
+	if sqlComment == "" {
+		return ""
+	}
+	return "/* " + sqlComment + " */ "
+}
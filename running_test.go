@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	gmstypes "github.com/dolthub/go-mysql-server/sql/types"
+)
+
+// the running work of two tenants
+const (
+	acc1StatementID = "018eb819-4048-7e69-aaa6-feb99965ea01"
+	acc1SessionID   = "018eb819-4048-7e69-aaa6-feb99965ea02"
+	acc2StatementID = "018eb819-4048-7e69-aaa6-feb99965eb01"
+	acc2SessionID   = "018eb819-4048-7e69-aaa6-feb99965eb02"
+)
+
+// startKillMO is a fake MO connected as caller with a running statement of acc1 and acc2,
+// on connection ids the fake does not use so the kills go nowhere.
+func startKillMO(t *testing.T, caller string) *FakeMO {
+	f := StartFakeMO(t, FakeMOOptions{Functions: map[string]FakeFunction{
+		"current_account_name": {Type: gmstypes.LongText, Fn: func([]any) (any, error) { return caller, nil }},
+	}})
+	if err := f.Insert("mo_catalog.processlist",
+		map[string]any{"conn_id": 1001, "session_id": acc1SessionID, "account": "acc1", "statement_id": acc1StatementID},
+		map[string]any{"conn_id": 1002, "session_id": acc2SessionID, "account": "acc2", "statement_id": acc2StatementID},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestKillAcrossAccounts(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		caller string
+		si     StatementInfo
+		err    error
+	}{
+		{name: "own statement", caller: "acc1", si: StatementInfo{StatementId: acc1StatementID}},
+		{name: "own session", caller: "acc1", si: StatementInfo{SessionId: acc1SessionID}},
+		{name: "other statement", caller: "acc1", si: StatementInfo{StatementId: acc2StatementID}, err: ErrPermissionDenied},
+		{name: "other session", caller: "acc1", si: StatementInfo{SessionId: acc2SessionID}, err: ErrPermissionDenied},
+		// the account of the request is not the caller, the connection is
+		{name: "claimed sys", caller: "acc1", si: StatementInfo{Account: sysAccount, StatementId: acc2StatementID}, err: ErrPermissionDenied},
+		{name: "sys", caller: sysAccount, si: StatementInfo{StatementId: acc2StatementID}},
+		{name: "ob", caller: obAccount, si: StatementInfo{SessionId: acc2SessionID}},
+		{name: "not running", caller: sysAccount, si: StatementInfo{StatementId: "018eb819-4048-7e69-aaa6-feb99965ec01"}, err: ErrNotRunning},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := startKillMO(t, tc.caller).DB()
+			var err error
+			if tc.si.StatementId != "" {
+				err = tc.si.KillStatement(db, NonUserRawComment)
+			} else {
+				err = tc.si.KillSession(db, NonUserRawComment)
+			}
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("got %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestKillLockHolderAcrossAccounts(t *testing.T) {
+	db := startKillMO(t, "acc1").DB()
+	err := KillLockHolder(db, LockWait{HolderSessionId: acc2SessionID, HolderAccount: "acc2"}, NonUserRawComment)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("got %v, want %v", err, ErrPermissionDenied)
+	}
+}
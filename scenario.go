@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const (
+	defaultSession = "default"
+
+	stepPass = "pass"
+	stepFail = "fail"
+)
+
+// Scenario is a repro script read from yaml, see scenarios/ for examples.
+type Scenario struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Connection overrides the connection the runner is given, field by field.
+	Connection Config `yaml:"connection"`
+	// Sessions are the connections of the scenario by name. Each one is pinned, so
+	// transactions and session variables carry over between its steps. A step without
+	// session runs on "default", which needs no declaration.
+	Sessions map[string]ScenarioSession `yaml:"sessions"`
+	// Comment is prepended to every statement as /* comment */, unless the step sets its own.
+	Comment string         `yaml:"comment"`
+	Steps   []ScenarioStep `yaml:"steps"`
+	// Assertions run after the steps, also when a step failed.
+	Assertions []ScenarioStep `yaml:"assertions"`
+}
+
+// ScenarioSession is the connection of a session, the non-zero fields of Config
+// override the connection of the scenario, or of Profile when set.
+type ScenarioSession struct {
+	Profile string `yaml:"profile"`
+	Config  `yaml:",inline"`
+	// Init runs once the session is connected, e.g. set statements.
+	Init []string `yaml:"init"`
+}
+
+// ScenarioStep is one of SQL, Sleep, Steps, which run in order, or Concurrent,
+// which run at the same time on different sessions. The Session of Steps and
+// Concurrent is the default of their steps.
+type ScenarioStep struct {
+	Name    string `yaml:"name"`
+	Session string `yaml:"session"`
+	SQL     string `yaml:"sql"`
+	// Comment overrides the comment of the scenario, empty means no comment.
+	Comment *string       `yaml:"comment"`
+	Sleep   time.Duration `yaml:"sleep"`
+	// Timeout cancels the statement with Cause, like context.WithTimeoutCause.
+	Timeout time.Duration   `yaml:"timeout"`
+	Cause   string          `yaml:"cause"`
+	Expect  *ScenarioExpect `yaml:"expect"`
+
+	Steps      []ScenarioStep `yaml:"steps"`
+	Concurrent []ScenarioStep `yaml:"concurrent"`
+}
+
+// ScenarioExpect is checked against the result of a SQL step. Without Error the
+// statement has to succeed.
+type ScenarioExpect struct {
+	// Error is a substring of the error, the cause of a timeout is part of the error.
+	Error   string   `yaml:"error"`
+	Columns []string `yaml:"columns"`
+	// Rows are the rows as text, null is NULL.
+	Rows     [][]*string `yaml:"rows"`
+	RowCount *int        `yaml:"rowCount"`
+	Affected *int64      `yaml:"affected"`
+	// Null tells per column whether every row is NULL or every row is not. The key is
+	// isNull, a yaml null key would be read as null.
+	Null        map[string]bool `yaml:"isNull"`
+	MinDuration time.Duration   `yaml:"minDuration"`
+	MaxDuration time.Duration   `yaml:"maxDuration"`
+}
+
+// ScenarioStepResult is the outcome of one SQL or sleep step.
+type ScenarioStepResult struct {
+	Step      string        `json:"step"`
+	Session   string        `json:"session,omitempty"`
+	Statement string        `json:"statement,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Status    string        `json:"status"`
+	Message   string        `json:"message,omitempty"`
+}
+
+type ScenarioResult struct {
+	Name   string               `json:"name"`
+	Passed bool                 `json:"passed"`
+	Steps  []ScenarioStepResult `json:"steps"`
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+func (sc *Scenario) Validate() error {
+	if len(sc.Steps) == 0 && len(sc.Assertions) == 0 {
+		return errors.New("no steps")
+	}
+	for i := range sc.Steps {
+		if _, err := sc.validateStep(&sc.Steps[i], strconv.Itoa(i+1)); err != nil {
+			return err
+		}
+	}
+	for i := range sc.Assertions {
+		if _, err := sc.validateStep(&sc.Assertions[i], "assertion "+strconv.Itoa(i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateStep returns the sessions used by s, the concurrent branches may not share one.
+func (sc *Scenario) validateStep(s *ScenarioStep, path string) (map[string]bool, error) {
+	kinds := 0
+	for _, set := range []bool{s.SQL != "", s.Sleep > 0, len(s.Steps) > 0, len(s.Concurrent) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, fmt.Errorf("step %s: set exactly one of sql, sleep, steps and concurrent", path)
+	}
+	if s.SQL == "" && (s.Expect != nil || s.Timeout > 0) {
+		return nil, fmt.Errorf("step %s: timeout and expect need sql", path)
+	}
+	if s.Sleep > 0 && s.Session != "" {
+		return nil, fmt.Errorf("step %s: sleep has no session", path)
+	}
+	// the session of a group is the default of its steps
+	for _, sub := range [][]ScenarioStep{s.Steps, s.Concurrent} {
+		for i := range sub {
+			if sub[i].Session == "" && sub[i].Sleep == 0 {
+				sub[i].Session = s.Session
+			}
+		}
+	}
+	if s.Cause != "" && s.Timeout <= 0 {
+		return nil, fmt.Errorf("step %s: cause needs timeout", path)
+	}
+
+	used := make(map[string]bool)
+	switch {
+	case s.SQL != "":
+		name := s.Session
+		if name == "" {
+			name = defaultSession
+		}
+		if _, ok := sc.Sessions[name]; !ok && name != defaultSession {
+			return nil, fmt.Errorf("step %s: unknown session %q", path, name)
+		}
+		used[name] = true
+	case len(s.Steps) > 0:
+		for i := range s.Steps {
+			sub, err := sc.validateStep(&s.Steps[i], path+"."+strconv.Itoa(i+1))
+			if err != nil {
+				return nil, err
+			}
+			for name := range sub {
+				used[name] = true
+			}
+		}
+	case len(s.Concurrent) > 0:
+		for i := range s.Concurrent {
+			sub, err := sc.validateStep(&s.Concurrent[i], path+"."+strconv.Itoa(i+1))
+			if err != nil {
+				return nil, err
+			}
+			for name := range sub {
+				if used[name] {
+					return nil, fmt.Errorf("step %s: session %q is used by two concurrent steps", path, name)
+				}
+				used[name] = true
+			}
+		}
+	}
+	return used, nil
+}
+
+// mergeConfig returns base with the non-zero fields of over.
+func mergeConfig(base, over Config) Config {
+	if over.Host != "" {
+		base.Host = over.Host
+	}
+	if over.Port != 0 {
+		base.Port = over.Port
+	}
+	if over.Username != "" {
+		base.Username = over.Username
+	}
+	if over.Password != "" {
+		base.Password = over.Password
+	}
+	if over.Database != "" {
+		base.Database = over.Database
+	}
+	if over.PPV2Enabled {
+		base.PPV2Enabled = true
+	}
+	if over.ClientIP != "" {
+		base.ClientIP = over.ClientIP
+	}
+	if over.TimeZone != "" {
+		base.TimeZone = over.TimeZone
+	}
+	return base
+}
+
+// ScenarioRunner runs scenarios through OpenDB.
+type ScenarioRunner struct {
+	// Base is the connection of the scenarios, Profiles resolves the profiles of the sessions.
+	Base     Config
+	Profiles Profiles
+	Logger   gormlogger.Interface
+}
+
+// scenarioRun is the state of one run, the sessions are connected on first use.
+type scenarioRun struct {
+	runner   *ScenarioRunner
+	sc       *Scenario
+	mu       sync.Mutex
+	sessions map[string]*sql.Conn
+	closers  []func() error
+}
+
+// Run executes the steps of sc in order, stopping at the first failed step, then its
+// assertions. The error is only for a run that could not start, the failed steps are in
+// the result.
+func (r *ScenarioRunner) Run(ctx context.Context, sc *Scenario) (*ScenarioResult, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	run := &scenarioRun{runner: r, sc: sc, sessions: make(map[string]*sql.Conn)}
+	defer run.close()
+
+	result := &ScenarioResult{Name: sc.Name, Passed: true}
+	for i := range sc.Steps {
+		steps, ok := run.step(ctx, &sc.Steps[i], strconv.Itoa(i+1))
+		result.Steps = append(result.Steps, steps...)
+		if !ok {
+			result.Passed = false
+			break
+		}
+	}
+	for i := range sc.Assertions {
+		steps, ok := run.step(ctx, &sc.Assertions[i], "assertion "+strconv.Itoa(i+1))
+		result.Steps = append(result.Steps, steps...)
+		result.Passed = result.Passed && ok
+	}
+	return result, nil
+}
+
+func (run *scenarioRun) close() {
+	for _, c := range run.sessions {
+		c.Close()
+	}
+	for _, c := range run.closers {
+		c()
+	}
+}
+
+// session returns the pinned connection of name, connecting it on first use.
+func (run *scenarioRun) session(ctx context.Context, name string) (*sql.Conn, error) {
+	run.mu.Lock()
+	conn, ok := run.sessions[name]
+	run.mu.Unlock()
+	if ok {
+		return conn, nil
+	}
+
+	// connect without the lock, a slow connection would hold up the steps of the other sessions
+	spec := run.sc.Sessions[name]
+	cfg := mergeConfig(run.runner.Base, run.sc.Connection)
+	if spec.Profile != "" {
+		p, err := run.runner.Profiles.Get(spec.Profile)
+		if err != nil {
+			return nil, err
+		}
+		cfg = p
+	}
+	cfg = mergeConfig(cfg, spec.Config)
+	db, err := OpenDB(cfg, run.runner.Logger, InterpolateParams(true))
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err = sqlDB.Conn(ctx)
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	for _, stmt := range spec.Init {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			conn.Close()
+			sqlDB.Close()
+			return nil, fmt.Errorf("init %q: %w", stmt, err)
+		}
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	// a concurrent step of the same session may have connected first
+	if existing, ok := run.sessions[name]; ok {
+		conn.Close()
+		sqlDB.Close()
+		return existing, nil
+	}
+	run.sessions[name] = conn
+	run.closers = append(run.closers, sqlDB.Close)
+	return conn, nil
+}
+
+// drop forgets a session whose connection broke, the next step connects again.
+func (run *scenarioRun) drop(name string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if conn, ok := run.sessions[name]; ok {
+		conn.Close()
+		delete(run.sessions, name)
+	}
+}
+
+// step runs s and reports whether all of its SQL and sleep steps passed. path is the
+// position of s, replaced by its name when set, and prefixes the paths of its steps.
+func (run *scenarioRun) step(ctx context.Context, s *ScenarioStep, path string) ([]ScenarioStepResult, bool) {
+	if s.Name != "" {
+		path = s.Name
+	}
+	switch {
+	case s.Sleep > 0:
+		res := ScenarioStepResult{Step: path, Duration: s.Sleep, Status: stepPass, Message: "sleep"}
+		select {
+		case <-time.After(s.Sleep):
+		case <-ctx.Done():
+			res.Status, res.Message = stepFail, ctx.Err().Error()
+		}
+		return []ScenarioStepResult{res}, res.Status == stepPass
+	case len(s.Steps) > 0:
+		var out []ScenarioStepResult
+		for i := range s.Steps {
+			steps, ok := run.step(ctx, &s.Steps[i], path+"."+strconv.Itoa(i+1))
+			out = append(out, steps...)
+			if !ok {
+				return out, false
+			}
+		}
+		return out, true
+	case len(s.Concurrent) > 0:
+		branches := make([][]ScenarioStepResult, len(s.Concurrent))
+		passed := make([]bool, len(s.Concurrent))
+		var wg sync.WaitGroup
+		for i := range s.Concurrent {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				branches[i], passed[i] = run.step(ctx, &s.Concurrent[i], path+"."+strconv.Itoa(i+1))
+			}(i)
+		}
+		wg.Wait()
+		var out []ScenarioStepResult
+		ok := true
+		for i := range branches {
+			out = append(out, branches[i]...)
+			ok = ok && passed[i]
+		}
+		return out, ok
+	}
+	res := run.sql(ctx, s, path)
+	return []ScenarioStepResult{res}, res.Status == stepPass
+}
+
+func (run *scenarioRun) sql(ctx context.Context, s *ScenarioStep, path string) ScenarioStepResult {
+	name := s.Session
+	if name == "" {
+		name = defaultSession
+	}
+	stmt := strings.TrimSuffix(strings.TrimSpace(s.SQL), ";")
+	res := ScenarioStepResult{Step: path, Session: name, Statement: stmt, Status: stepPass}
+	conn, err := run.session(ctx, name)
+	if err != nil {
+		res.Status, res.Message = stepFail, "connect: "+err.Error()
+		return res
+	}
+
+	comment := run.sc.Comment
+	if s.Comment != nil {
+		comment = *s.Comment
+	}
+	query := stmt
+	if comment != "" {
+		query = "/* " + comment + " */ " + stmt
+	}
+	stmtCtx := ctx
+	if s.Timeout > 0 {
+		var cause error
+		if s.Cause != "" {
+			cause = errors.New(s.Cause)
+		}
+		var cancel context.CancelFunc
+		stmtCtx, cancel = context.WithTimeoutCause(ctx, s.Timeout, cause)
+		defer cancel()
+	}
+
+	begin := time.Now()
+	rs, affected, err := runStatement(stmtCtx, conn, stmt, query)
+	res.Duration = time.Since(begin)
+	if err != nil {
+		if cause := context.Cause(stmtCtx); cause != nil && !errors.Is(err, cause) {
+			err = fmt.Errorf("%w (cause: %v)", err, cause)
+		}
+		if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || stmtCtx.Err() != nil {
+			// the driver closes the connection of a canceled statement
+			run.drop(name)
+		}
+	}
+
+	failures := checkExpect(s.Expect, rs, affected, res.Duration, err)
+	switch {
+	case len(failures) > 0:
+		res.Status, res.Message = stepFail, strings.Join(failures, "; ")
+	case err != nil:
+		res.Message = err.Error()
+	case rs != nil:
+		res.Message = fmt.Sprintf("%d rows in set", len(rs.Rows))
+	default:
+		res.Message = fmt.Sprintf("%d rows affected", affected)
+	}
+	return res
+}
+
+// checkExpect returns what in the result of a step differs from e.
+func checkExpect(e *ScenarioExpect, rs *ResultSet, affected int64, elapsed time.Duration, err error) []string {
+	if e == nil {
+		e = &ScenarioExpect{}
+	}
+	var failures []string
+	if e.MinDuration > 0 && elapsed < e.MinDuration {
+		failures = append(failures, fmt.Sprintf("took %s, expected at least %s", humanDuration(elapsed), humanDuration(e.MinDuration)))
+	}
+	if e.MaxDuration > 0 && elapsed > e.MaxDuration {
+		failures = append(failures, fmt.Sprintf("took %s, expected at most %s", humanDuration(elapsed), humanDuration(e.MaxDuration)))
+	}
+	if e.Error != "" {
+		if err == nil {
+			return append(failures, fmt.Sprintf("expected error %q, got none", e.Error))
+		}
+		if !strings.Contains(strings.ToLower(err.Error()), strings.ToLower(e.Error)) {
+			failures = append(failures, fmt.Sprintf("expected error %q, got %q", e.Error, err.Error()))
+		}
+		return failures
+	}
+	if err != nil {
+		return append(failures, err.Error())
+	}
+
+	if e.Affected != nil && affected != *e.Affected {
+		failures = append(failures, fmt.Sprintf("%d rows affected, expected %d", affected, *e.Affected))
+	}
+	if rs == nil {
+		if e.Columns != nil || e.Rows != nil || e.RowCount != nil || e.Null != nil {
+			failures = append(failures, "expected a result set")
+		}
+		return failures
+	}
+	if e.Columns != nil && strings.Join(rs.Columns, ",") != strings.Join(e.Columns, ",") {
+		failures = append(failures, fmt.Sprintf("columns %v, expected %v", rs.Columns, e.Columns))
+	}
+	if e.RowCount != nil && len(rs.Rows) != *e.RowCount {
+		failures = append(failures, fmt.Sprintf("%d rows, expected %d", len(rs.Rows), *e.RowCount))
+	}
+	if e.Rows != nil {
+		if len(rs.Rows) != len(e.Rows) {
+			failures = append(failures, fmt.Sprintf("%d rows, expected %d", len(rs.Rows), len(e.Rows)))
+		} else {
+			for i, row := range rs.Rows {
+				if got, want := formatScenarioRow(row), formatExpectedRow(e.Rows[i]); got != want {
+					failures = append(failures, fmt.Sprintf("row %d is %s, expected %s", i+1, got, want))
+				}
+			}
+		}
+	}
+	for col, null := range e.Null {
+		idx := -1
+		for i, c := range rs.Columns {
+			if c == col {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			failures = append(failures, fmt.Sprintf("no column %s", col))
+			continue
+		}
+		for i, row := range rs.Rows {
+			if (row[idx] == nil) != null {
+				failures = append(failures, fmt.Sprintf("row %d: %s is %s, expected %s", i+1, col, nullness(row[idx] == nil), nullness(null)))
+			}
+		}
+	}
+	return failures
+}
+
+func nullness(null bool) string {
+	if null {
+		return "NULL"
+	}
+	return "not NULL"
+}
+
+func formatScenarioRow(row []any) string {
+	cells := make([]string, len(row))
+	for i, v := range row {
+		if v == nil {
+			cells[i] = "NULL"
+		} else {
+			cells[i] = strconv.Quote(v.(string))
+		}
+	}
+	return "[" + strings.Join(cells, ", ") + "]"
+}
+
+func formatExpectedRow(row []*string) string {
+	cells := make([]string, len(row))
+	for i, v := range row {
+		if v == nil {
+			cells[i] = "NULL"
+		} else {
+			cells[i] = strconv.Quote(*v)
+		}
+	}
+	return "[" + strings.Join(cells, ", ") + "]"
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+const (
+	defaultTailInterval = 2 * time.Second
+	defaultTailOverlap  = 30 * time.Second
+	defaultTailBatch    = 1000
+)
+
+// tailProj leaves exec_plan and stats out, a follower reads every statement.
+const tailProj = "statement_id, transaction_id, session_id, account, `user`, host, `database`, statement, " +
+	"statement_fingerprint, node_uuid, node_type, request_at, response_at, duration, status, err_code, error, " +
+	"rows_read, bytes_scan, result_count, sql_source_type"
+
+type TailOptions struct {
+	// Account, User, MinDuration and ErrorsOnly filter the statements, empty or zero means all.
+	Account     string
+	User        string
+	MinDuration time.Duration
+	ErrorsOnly  bool
+	// Since is the request_at to start from, default now.
+	Since    time.Time
+	Interval time.Duration
+	// Overlap is how far behind the high-water mark each poll looks again, a statement is
+	// written to statement_info when it finishes, so one requested before the mark can
+	// arrive later. Statements arriving later than Overlap are missed.
+	Overlap    time.Duration
+	BatchSize  int
+	SQLComment string
+}
+
+// Tailer follows system.statement_info for new finished statements. It keeps a high-water
+// mark on (request_at, statement_id) and the ids seen within the overlap, so each statement
+// is emitted once.
+type Tailer struct {
+	db   *gorm.DB
+	opts TailOptions
+
+	hwmAt time.Time
+	hwmID string
+	// seen holds the request_at of the statements emitted within the overlap.
+	seen map[string]time.Time
+}
+
+func NewTailer(db *gorm.DB, opts TailOptions) *Tailer {
+	if opts.Since.IsZero() {
+		opts.Since = time.Now()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultTailInterval
+	}
+	if opts.Overlap <= 0 {
+		opts.Overlap = defaultTailOverlap
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultTailBatch
+	}
+	if opts.SQLComment == "" {
+		opts.SQLComment = NonUserRawComment
+	}
+	return &Tailer{db: db, opts: opts, hwmAt: opts.Since, seen: make(map[string]time.Time)}
+}
+
+func (t *Tailer) query(ctx context.Context, lower time.Time) *gorm.DB {
+	query := t.db.WithContext(ctx).Clauses(hints.CommentBefore("SELECT", t.opts.SQLComment)).
+		Table(statementInfoDBTable).Select(tailProj).
+		Where("request_at >= ? and status != ?", lower, runningStatus)
+	if t.opts.Account != "" {
+		query = query.Where("account = ?", t.opts.Account)
+	}
+	if t.opts.User != "" {
+		query = query.Where("`user` = ?", t.opts.User)
+	}
+	if t.opts.MinDuration > 0 {
+		query = query.Where("duration >= ?", uint64(t.opts.MinDuration))
+	}
+	if t.opts.ErrorsOnly {
+		query = query.Where("status = ?", failedStatus)
+	}
+	return query
+}
+
+// Poll returns the statements that arrived since the last poll, ordered by (request_at, statement_id).
+func (t *Tailer) Poll(ctx context.Context) ([]StatementInfo, error) {
+	lower := t.hwmAt.Add(-t.opts.Overlap)
+	if lower.Before(t.opts.Since) {
+		lower = t.opts.Since
+	}
+
+	var (
+		out    []StatementInfo
+		lastAt time.Time
+		lastID string
+	)
+	for {
+		query := t.query(ctx, lower)
+		if lastID != "" {
+			query = query.Where("(request_at > ? or (request_at = ? and statement_id > ?))", lastAt, lastAt, lastID)
+		}
+		batch := make([]StatementInfo, 0, t.opts.BatchSize)
+		if err := query.Order("request_at, statement_id").Limit(t.opts.BatchSize).Scan(&batch).Error; err != nil {
+			return out, err
+		}
+		for _, s := range batch {
+			if s.RequestAt == nil {
+				continue
+			}
+			lastAt, lastID = *s.RequestAt, s.StatementId
+			if _, ok := t.seen[s.StatementId]; ok {
+				continue
+			}
+			t.seen[s.StatementId] = *s.RequestAt
+			if s.RequestAt.After(t.hwmAt) || (s.RequestAt.Equal(t.hwmAt) && s.StatementId > t.hwmID) {
+				t.hwmAt, t.hwmID = *s.RequestAt, s.StatementId
+			}
+			out = append(out, s)
+		}
+		if len(batch) < t.opts.BatchSize {
+			break
+		}
+	}
+
+	// the ids older than the next lower bound are not read again
+	horizon := t.hwmAt.Add(-t.opts.Overlap)
+	for id, at := range t.seen {
+		if at.Before(horizon) {
+			delete(t.seen, id)
+		}
+	}
+	return out, nil
+}
+
+// Run polls every Interval until ctx is done or emit fails. A failed poll is logged and
+// retried on the next tick.
+func (t *Tailer) Run(ctx context.Context, emit func(StatementInfo) error) error {
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	for {
+		records, err := t.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Warn(ctx, "tail statement_info: %v", err)
+		}
+		for _, s := range records {
+			if err := emit(s); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Chan runs t in a goroutine and sends the statements to the returned channel,
+// which is closed when ctx is done.
+func (t *Tailer) Chan(ctx context.Context) <-chan StatementInfo {
+	ch := make(chan StatementInfo)
+	go func() {
+		defer close(ch)
+		t.Run(ctx, func(s StatementInfo) error {
+			select {
+			case ch <- s:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return ch
+}
@@ -0,0 +1,3 @@
+-- args: "018eb819-4048-7e69-aaa6-feb99965eb97", "query_tae_table", 2024-03-25T10:40:16Z, "018eb819-4048-7e69-aaa6-feb99965eb97", "query_tae_table", 2024-03-25T10:40:16Z, 1
+/* cloud_nonuser */ SELECT `statement`, system.statement_info.statement_id, IF(`status`='Running', TIMESTAMPDIFF(MICROSECOND,`request_at`,now())*1000, `duration`) AS `duration`, `status`, `request_at`, system.statement_info.response_at, `user`, system.statement_info.account, `database`, `transaction_id`, `session_id`, `rows_read`, `bytes_scan`, `error`, `err_code`, `result_count`, tmpcu.cu AS `cu` FROM `system`.`statement_info` left join (select * from mo_catalog.statement_cu where statement_id = ? and account = ? and response_at >= ?)tmpcu ON system.statement_info.statement_id = tmpcu.statement_id WHERE system.statement_info.statement_id = ? and system.statement_info.account = ? and request_at >= ? ORDER BY system.statement_info.response_at DESC LIMIT ?;
+
@@ -0,0 +1,3 @@
+-- args: "018eb819-4048-7e69-aaa6-feb99965eb97", "query_tae_table", 2024-03-25T10:40:16Z, 2024-03-25T11:20:16Z, 1
+/* cloud_nonuser */ SELECT `statement`, system.statement_info.statement_id, IF(`status`='Running', TIMESTAMPDIFF(MICROSECOND,`request_at`,now())*1000, `duration`) AS `duration`, `status`, `request_at`, system.statement_info.response_at, `user`, system.statement_info.account, `database`, `transaction_id`, `session_id`, `rows_read`, `bytes_scan`, `error`, `err_code`, `result_count`, IF(status = 'Running', NULL, CAST(IF(JSON_UNQUOTE(JSON_EXTRACT(stats, '$[0]')) >= 4, JSON_UNQUOTE(JSON_EXTRACT(stats, '$[8]')), mo_cu_v1(stats, duration)) AS DECIMAL(32,4))) AS `cu` FROM `system`.`statement_info` WHERE system.statement_info.statement_id = ? and system.statement_info.account = ? and request_at >= ? and request_at <= ? ORDER BY system.statement_info.response_at DESC LIMIT ?;
+
@@ -0,0 +1,4 @@
+/* cloud_nonuser */ SELECT `statement`, system.statement_info.statement_id, IF(`status`='Running', TIMESTAMPDIFF(MICROSECOND,`request_at`,now())*1000, `duration`) AS `duration`, `status`, `request_at`, system.statement_info.response_at, `user`, system.statement_info.account, `database`, `transaction_id`, `session_id`, `rows_read`, `bytes_scan`, `error`, `err_code`, `result_count` FROM `system`.`statement_info` WHERE system.statement_info.account = 'query_tae_table' and request_at >= '2024-03-25 10:40:16' and request_at < '2024-03-25 11:20:16' and `user` = 'dump' and `database` = 'test' and status = 'Failed' and statement like '%user!_info%' escape '!' ORDER BY duration LIMIT 50 OFFSET 100;
+
+/* cloud_nonuser */ SELECT count(*) FROM `system`.`statement_info` WHERE system.statement_info.account = 'query_tae_table' and request_at >= '2024-03-25 10:40:16' and request_at < '2024-03-25 11:20:16' and `user` = 'dump' and `database` = 'test' and status = 'Failed' and statement like '%user!_info%' escape '!';
+
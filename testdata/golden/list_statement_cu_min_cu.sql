@@ -0,0 +1,4 @@
+/* cloud_nonuser */ SELECT * FROM (select `statement`, system.statement_info.statement_id, IF(`status`='Running', TIMESTAMPDIFF(MICROSECOND,`request_at`,now())*1000, `duration`) AS `duration`, `status`, `request_at`, system.statement_info.response_at, `user`, system.statement_info.account, `database`, `transaction_id`, `session_id`, `rows_read`, `bytes_scan`, `error`, `err_code`, `result_count`, tmpcu.cu AS `cu` from system.statement_info left join (select * from mo_catalog.statement_cu where account = 'query_tae_table' and response_at >= '2024-03-25 10:40:16' and response_at <= '2024-03-25 11:30:16')tmpcu ON system.statement_info.statement_id = tmpcu.statement_id where system.statement_info.account = 'query_tae_table' and request_at >= '2024-03-25 10:40:16' and request_at < '2024-03-25 11:20:16')t WHERE cu > 5 ORDER BY request_at desc LIMIT 20;
+
+/* cloud_nonuser */ SELECT count(*) FROM (select `statement`, system.statement_info.statement_id, IF(`status`='Running', TIMESTAMPDIFF(MICROSECOND,`request_at`,now())*1000, `duration`) AS `duration`, `status`, `request_at`, system.statement_info.response_at, `user`, system.statement_info.account, `database`, `transaction_id`, `session_id`, `rows_read`, `bytes_scan`, `error`, `err_code`, `result_count`, tmpcu.cu AS `cu` from system.statement_info left join (select * from mo_catalog.statement_cu where account = 'query_tae_table' and response_at >= '2024-03-25 10:40:16' and response_at <= '2024-03-25 11:30:16')tmpcu ON system.statement_info.statement_id = tmpcu.statement_id where system.statement_info.account = 'query_tae_table' and request_at >= '2024-03-25 10:40:16' and request_at < '2024-03-25 11:20:16')t WHERE cu > 5;
+
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+const (
+	TimelineTransaction = "transaction"
+	TimelineSession     = "session"
+
+	defaultGanttWidth = 60
+)
+
+const timelineProj = "statement_id, statement, status, err_code, request_at, " +
+	"IF(status = 'Running', TIMESTAMPDIFF(MICROSECOND, request_at, now())*1000, duration) AS duration, " +
+	"IF(status = 'Running', NULL, " + cuExpr + ") AS cu"
+
+type timelineRow struct {
+	StatementId string
+	Statement   string
+	Status      string
+	ErrCode     string
+	RequestAt   time.Time
+	Duration    uint64
+	CU          *float64
+}
+
+type TimelineEntry struct {
+	StatementId string        `json:"statement_id"`
+	Statement   string        `json:"statement"`
+	Status      string        `json:"status"`
+	ErrCode     string        `json:"error_code,omitempty"`
+	RequestAt   time.Time     `json:"request_at"`
+	EndAt       time.Time     `json:"end_at"`
+	Duration    time.Duration `json:"duration"`
+	CU          *float64      `json:"cu,omitempty"`
+	// Offset is the start of the statement relative to the start of the timeline.
+	Offset time.Duration `json:"offset"`
+	// Gap is the idle time since the latest end of the previous statements,
+	// negative when this statement started before they finished.
+	Gap                time.Duration `json:"gap"`
+	Overlaps           bool          `json:"overlaps"`
+	StatusChanged      bool          `json:"status_changed"`
+	CumulativeDuration time.Duration `json:"cumulative_duration"`
+}
+
+type Timeline struct {
+	Kind          string          `json:"kind"`
+	Id            string          `json:"id"`
+	Start         time.Time       `json:"start"`
+	End           time.Time       `json:"end"`
+	WallTime      time.Duration   `json:"wall_time"`
+	TotalDuration time.Duration   `json:"total_duration"`
+	TotalGap      time.Duration   `json:"total_gap"`
+	TotalCU       float64         `json:"total_cu"`
+	Entries       []TimelineEntry `json:"entries"`
+}
+
+// SelectTransactionTimeline returns every statement of transaction p.TransactionId in p.Account.
+func (p StatementInfo) SelectTransactionTimeline(db *gorm.DB, sqlComment string) (*Timeline, error) {
+	if p.TransactionId == "" {
+		return nil, errors.New("Invalid Params")
+	}
+	return p.selectTimeline(db, sqlComment, TimelineTransaction, "transaction_id", p.TransactionId)
+}
+
+// SelectSessionTimeline returns every statement of session p.SessionId in p.Account.
+func (p StatementInfo) SelectSessionTimeline(db *gorm.DB, sqlComment string) (*Timeline, error) {
+	if p.SessionId == "" {
+		return nil, errors.New("Invalid Params")
+	}
+	return p.selectTimeline(db, sqlComment, TimelineSession, "session_id", p.SessionId)
+}
+
+func (p StatementInfo) selectTimeline(db *gorm.DB, sqlComment, kind, col, id string) (*Timeline, error) {
+	if p.Account == "" {
+		return nil, errors.New("Invalid Params")
+	}
+	rows := make([]timelineRow, 0)
+	if err := db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).Select(timelineProj).
+		Where(col+" = ? and account = ?", id, p.Account).
+		Order("request_at, statement_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return buildTimeline(kind, id, rows), nil
+}
+
+func buildTimeline(kind, id string, rows []timelineRow) *Timeline {
+	t := &Timeline{Kind: kind, Id: id, Start: rows[0].RequestAt, Entries: make([]TimelineEntry, 0, len(rows))}
+	var latestEnd time.Time
+	for i, row := range rows {
+		e := TimelineEntry{
+			StatementId: row.StatementId,
+			Statement:   row.Statement,
+			Status:      row.Status,
+			ErrCode:     row.ErrCode,
+			RequestAt:   row.RequestAt,
+			Duration:    time.Duration(row.Duration),
+			CU:          row.CU,
+			Offset:      row.RequestAt.Sub(t.Start),
+		}
+		e.EndAt = e.RequestAt.Add(e.Duration)
+		if i > 0 {
+			e.Gap = e.RequestAt.Sub(latestEnd)
+			e.Overlaps = e.Gap < 0
+			e.StatusChanged = row.Status != rows[i-1].Status
+			if e.Gap > 0 {
+				t.TotalGap += e.Gap
+			}
+		}
+		if e.EndAt.After(latestEnd) {
+			latestEnd = e.EndAt
+		}
+		t.TotalDuration += e.Duration
+		e.CumulativeDuration = t.TotalDuration
+		if e.CU != nil {
+			t.TotalCU += *e.CU
+		}
+		t.Entries = append(t.Entries, e)
+	}
+	t.End = latestEnd
+	t.WallTime = t.End.Sub(t.Start)
+	return t
+}
+
+// WriteGantt renders the timeline as an ascii gantt chart, one line per statement,
+// width is the number of columns of the bar area.
+func (t *Timeline) WriteGantt(w io.Writer, width int) error {
+	if width <= 0 {
+		width = defaultGanttWidth
+	}
+	if _, err := fmt.Fprintf(w, "%s %s  start=%s  wall=%s  busy=%s  idle=%s  cu=%.4f\n",
+		t.Kind, t.Id, t.Start.Format(time.RFC3339Nano), t.WallTime, t.TotalDuration, t.TotalGap, t.TotalCU); err != nil {
+		return err
+	}
+	scale := float64(t.WallTime) / float64(width)
+	for _, e := range t.Entries {
+		from, to := 0, width
+		if scale > 0 {
+			from = int(float64(e.Offset) / scale)
+			to = int(float64(e.Offset+e.Duration) / scale)
+		}
+		if from >= width {
+			from = width - 1
+		}
+		if to <= from {
+			to = from + 1
+		}
+		if to > width {
+			to = width
+		}
+		mark := "#"
+		switch {
+		case e.Status == runningStatus:
+			mark = ">"
+		case e.Status == failedStatus:
+			mark = "x"
+		}
+		bar := strings.Repeat(" ", from) + strings.Repeat(mark, to-from) + strings.Repeat(" ", width-to)
+		flag := " "
+		if e.Overlaps {
+			flag = "!"
+		}
+		if _, err := fmt.Fprintf(w, "%-8.8s %s|%s| %12s %-8s %s\n",
+			e.StatementId, flag, bar, e.Duration, e.Status, truncateStatement(e.Statement, 60)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func truncateStatement(sql string, n int) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if r := []rune(sql); len(r) > n {
+		if n < 4 {
+			// no room for the ellipsis
+			return string(r[:max(n, 0)])
+		}
+		return string(r[:n-3]) + "..."
+	}
+	return sql
+}
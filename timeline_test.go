@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	cu := func(v float64) *float64 { return &v }
+	row := func(id string, at, d time.Duration, status string, cu *float64) timelineRow {
+		return timelineRow{StatementId: id, Status: status, RequestAt: fixtureStart.Add(at), Duration: uint64(d), CU: cu}
+	}
+	for _, tc := range []struct {
+		name string
+		rows []timelineRow
+		// entries are id:offset:gap:overlaps:status_changed:cumulative_duration
+		entries string
+		// totals are wall:busy:idle:cu
+		totals string
+	}{
+		{
+			name:    "single",
+			rows:    []timelineRow{row("s1", 0, time.Second, "Success", cu(0.5))},
+			entries: "s1:0s:0s:false:false:1s",
+			totals:  "1s:1s:0s:0.5",
+		},
+		{
+			name: "gaps",
+			rows: []timelineRow{
+				row("s1", 0, time.Second, "Success", cu(0.5)),
+				row("s2", 2*time.Second, time.Second, "Success", cu(0.25)),
+				row("s3", 5*time.Second, 2*time.Second, failedStatus, nil),
+			},
+			entries: "s1:0s:0s:false:false:1s s2:2s:1s:false:false:2s s3:5s:2s:false:true:4s",
+			totals:  "7s:4s:3s:0.75",
+		},
+		{
+			// s3 starts while s2 runs, the gap of s4 is from the end of s2
+			name: "overlap",
+			rows: []timelineRow{
+				row("s1", 0, time.Second, "Success", nil),
+				row("s2", time.Second, 4*time.Second, "Success", nil),
+				row("s3", 2*time.Second, time.Second, runningStatus, nil),
+				row("s4", 6*time.Second, time.Second, "Success", nil),
+			},
+			entries: "s1:0s:0s:false:false:1s s2:1s:0s:false:false:5s s3:2s:-3s:true:true:6s s4:6s:1s:false:true:7s",
+			totals:  "7s:7s:1s:0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := buildTimeline(TimelineSession, "sess", tc.rows)
+			var entries []string
+			for _, e := range tl.Entries {
+				entries = append(entries, fmt.Sprintf("%s:%s:%s:%v:%v:%s",
+					e.StatementId, e.Offset, e.Gap, e.Overlaps, e.StatusChanged, e.CumulativeDuration))
+			}
+			if got := strings.Join(entries, " "); got != tc.entries {
+				t.Errorf("entries\ngot  %s\nwant %s", got, tc.entries)
+			}
+			if got := fmt.Sprintf("%s:%s:%s:%g", tl.WallTime, tl.TotalDuration, tl.TotalGap, tl.TotalCU); got != tc.totals {
+				t.Errorf("totals got %s, want %s", got, tc.totals)
+			}
+			if !tl.Start.Equal(fixtureStart) || !tl.End.Equal(fixtureStart.Add(tl.WallTime)) {
+				t.Errorf("got %s - %s", tl.Start, tl.End)
+			}
+		})
+	}
+}
+
+func TestTruncateStatement(t *testing.T) {
+	for _, tc := range []struct {
+		sql  string
+		n    int
+		want string
+	}{
+		{"select 1", 60, "select 1"},
+		{"select\n  *\tfrom t", 60, "select * from t"},
+		{"select * from t", 10, "select ..."},
+		{"select * from t", 3, "sel"},
+		{"select * from t", 0, ""},
+		{"select * from t", -1, ""},
+	} {
+		if got := truncateStatement(tc.sql, tc.n); got != tc.want {
+			t.Errorf("truncateStatement(%q, %d) = %q, want %q", tc.sql, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the absolute time formats accepted by ParseTime, besides RFC3339.
+var timeLayouts = []string{time.DateTime, "2006-01-02 15:04", time.DateOnly}
+
+// TimeRange is either an absolute [Start, End) range or a window of length Last ending now.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+	Last  time.Duration
+}
+
+// Last returns the window of length d ending at the time it is resolved.
+func Last(d time.Duration) TimeRange {
+	return TimeRange{Last: d}
+}
+
+// Between returns the absolute range [start, end).
+func Between(start, end time.Time) TimeRange {
+	return TimeRange{Start: start, End: end}
+}
+
+// Resolve returns the absolute bounds of r, relative windows end at now.
+func (r TimeRange) Resolve(now time.Time) (time.Time, time.Time, error) {
+	if r.Last > 0 {
+		return now.Add(-r.Last), now, nil
+	}
+	if r.Start.IsZero() || r.End.IsZero() || !r.Start.Before(r.End) {
+		return time.Time{}, time.Time{}, errors.New("Invalid time range")
+	}
+	return r.Start, r.End, nil
+}
+
+func (r TimeRange) String() string {
+	if r.Last > 0 {
+		return "last " + r.Last.String()
+	}
+	return r.Start.Format(time.RFC3339) + "/" + r.End.Format(time.RFC3339)
+}
+
+// ParseTime parses RFC3339 or "2006-01-02 15:04:05" like times, the latter in loc.
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// ParseTimeRange accepts "last 15m", "15m" and "<start>/<end>" with both ends in a format of ParseTime.
+func ParseTimeRange(s string, loc *time.Location) (TimeRange, error) {
+	s = strings.TrimSpace(s)
+	if start, end, ok := strings.Cut(s, "/"); ok {
+		from, err := ParseTime(start, loc)
+		if err != nil {
+			return TimeRange{}, err
+		}
+		to, err := ParseTime(end, loc)
+		if err != nil {
+			return TimeRange{}, err
+		}
+		r := Between(from, to)
+		_, _, err = r.Resolve(time.Time{})
+		return r, err
+	}
+	d, err := ParsePromDuration(strings.TrimSpace(strings.TrimPrefix(s, "last")))
+	if err != nil || d <= 0 {
+		return TimeRange{}, fmt.Errorf("invalid time range: %s", s)
+	}
+	return Last(d), nil
+}
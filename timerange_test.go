@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{in: "2024-03-25T18:40:16+08:00", want: time.Date(2024, 3, 25, 10, 40, 16, 0, time.UTC)},
+		{in: "2024-03-25T10:40:16.5Z", want: time.Date(2024, 3, 25, 10, 40, 16, 5e8, time.UTC)},
+		{in: "2024-03-25 18:40:16", want: time.Date(2024, 3, 25, 18, 40, 16, 0, shanghai)},
+		{in: " 2024-03-25 18:40 ", want: time.Date(2024, 3, 25, 18, 40, 0, 0, shanghai)},
+		{in: "2024-03-25", want: time.Date(2024, 3, 25, 0, 0, 0, 0, shanghai)},
+		{in: "2024/03/25", err: true},
+		{in: "2024-03-25 25:00", err: true},
+		{in: "", err: true},
+	} {
+		got, err := ParseTime(tc.in, shanghai)
+		if (err != nil) != tc.err || !got.Equal(tc.want) {
+			t.Errorf("ParseTime(%q) = %s %v, want %s", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	start := time.Date(2024, 3, 25, 18, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in   string
+		want TimeRange
+		err  bool
+	}{
+		{in: "last 15m", want: Last(15 * time.Minute)},
+		{in: "15m", want: Last(15 * time.Minute)},
+		{in: " last 1h30m ", want: Last(90 * time.Minute)},
+		{in: "last 1d", want: Last(24 * time.Hour)},
+		{in: "2024-03-25 18:00/2024-03-25 19:00", want: Between(start, start.Add(time.Hour))},
+		{in: "2024-03-25T20:00:00+08:00/2024-03-25 19:00:00", want: Between(start.Add(-6*time.Hour), start.Add(time.Hour))},
+		{in: "last 0m", err: true},
+		{in: "last", err: true},
+		{in: "last 15", err: true},
+		{in: "yesterday", err: true},
+		{in: "2024-03-25 19:00/2024-03-25 18:00", err: true},
+		{in: "2024-03-25 18:00/2024-03-25 18:00", err: true},
+		{in: "2024-03-25 18:00/later", err: true},
+	} {
+		got, err := ParseTimeRange(tc.in, time.UTC)
+		if (err != nil) != tc.err {
+			t.Errorf("ParseTimeRange(%q): %v", tc.in, err)
+			continue
+		}
+		if !tc.err && (got.Last != tc.want.Last || !got.Start.Equal(tc.want.Start) || !got.End.Equal(tc.want.End)) {
+			t.Errorf("ParseTimeRange(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
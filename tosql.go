@@ -1,14 +1,12 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"golang.org/x/sync/errgroup"
-	"gorm.io/gorm/callbacks"
 
 	"gorm.io/gorm"
 	"gorm.io/hints"
@@ -16,6 +14,7 @@ import (
 
 const (
 	runningStatus        = "Running"
+	failedStatus         = "Failed"
 	statementInfoDBTable = "system.statement_info"
 	execPlanCol          = "exec_plan"
 	statsCol             = "stats"
@@ -31,6 +30,8 @@ const (
 	anySqlSourceType     = "any_value(`sql_source_type`) as `sql_source_type`"
 	anyResponseAt        = "any_value(`response_at`) as `response_at`"
 	anyAccount           = "any_value(`account`) as `account`"
+	// cuExpr calculates the CU of a finished statement from its stats
+	cuExpr = "CAST(IF(JSON_UNQUOTE(JSON_EXTRACT(stats, '$[0]')) >= 4, JSON_UNQUOTE(JSON_EXTRACT(stats, '$[8]')), mo_cu_v1(stats, duration)) AS DECIMAL(32,4))"
 )
 
 type StatementInfo struct {
@@ -85,8 +86,8 @@ type Stats struct {
 }
 
 func (p StatementInfo) SelectByStatementId(db *gorm.DB,
-	proj, start, end *string, sqlComment string, cu bool,
-	responseEnd *string, enableStatementCU bool) (*StatementInfo, error) {
+	proj *string, start, end *time.Time, sqlComment string, cu bool,
+	responseEnd *time.Time, enableStatementCU bool) (*StatementInfo, error) {
 	if p.StatementId == "" || proj == nil {
 		return nil, errors.New("Invalid Params")
 	}
@@ -136,90 +137,51 @@ func (p StatementInfo) SelectStatements(db *gorm.DB,
 	record := make([]StatementInfo, 0)
 	var querySelect, queryCount string
 	var count int64
-	var eg errgroup.Group
-	eg.Go(func() error {
-		if cu {
-			var tmpTableSQL string
-			var allArgs []any
-			if enableStatementCU {
-				tmpTableSQL = fmt.Sprintf("(select %s from system.statement_info left join (select * from mo_catalog.statement_cu where %s)tmpcu ON system.statement_info.statement_id = tmpcu.statement_id where %s)t", proj, joinCond, cond)
-				allArgs = append(joinArgs, args...)
-			} else {
-				tmpTableSQL = fmt.Sprintf("(select %s from system.statement_info where %s)t", proj, cond)
-				allArgs = append(allArgs, args...)
-			}
-			tmpTable := db.Table(tmpTableSQL, allArgs...)
-			if minCU != nil && *minCU > 0 {
-				querySelect = tmpTable.ToSQL(func(tx *gorm.DB) *gorm.DB {
-					return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Where("cu > ?", *minCU).Order(order).Offset(int(offset)).Limit(int(limit)).Scan(&record)
-				})
-			} else {
-				querySelect = tmpTable.ToSQL(func(tx *gorm.DB) *gorm.DB {
-					//query := tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Order(order).Offset(int(offset)).Limit(int(limit))
-					//if stmt := query.Statement; len(stmt.BuildClauses) == 0 {
-					//	//stmt.BuildClauses = query
-					//	//resetBuildClauses := true
-					//}
-					//callbacks.BuildQuerySQL(query)
-					//logger.Info(context.TODO(), "query: %s", query.Statement.SQL.String())
-					//return query
-					return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Order(order).Offset(int(offset)).Limit(int(limit)).Scan(&record)
-				})
-			}
+
+	// tmpTable selects the statements with their CU, joining statement_cu when it has the CU
+	tmpTable := func() *gorm.DB {
+		if enableStatementCU {
+			tmpTableSQL := fmt.Sprintf("(select %s from system.statement_info left join (select * from mo_catalog.statement_cu where %s)tmpcu ON system.statement_info.statement_id = tmpcu.statement_id where %s)t", proj, joinCond, cond)
+			return db.Table(tmpTableSQL, append(append([]any{}, joinArgs...), args...)...)
+		}
+		tmpTableSQL := fmt.Sprintf("(select %s from system.statement_info where %s)t", proj, cond)
+		return db.Table(tmpTableSQL, args...)
+	}
+
+	// the select is built before the count starts, the goroutine only touches queryCount and count
+	if cu {
+		if minCU != nil && *minCU > 0 {
+			querySelect = tmpTable().ToSQL(func(tx *gorm.DB) *gorm.DB {
+				return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Where("cu > ?", *minCU).Order(order).Offset(int(offset)).Limit(int(limit)).Scan(&record)
+			})
 		} else {
-			querySelect = db.ToSQL(func(tx *gorm.DB) *gorm.DB {
-				return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).Select(proj).Where(cond, args...).Order(order).Offset(int(offset)).Limit(int(limit)).Scan(&record)
+			querySelect = tmpTable().ToSQL(func(tx *gorm.DB) *gorm.DB {
+				return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Order(order).Offset(int(offset)).Limit(int(limit)).Scan(&record)
 			})
 		}
-		return db.Raw(querySelect).Scan(&record).Error
-	})
-
-	eg.Go(func() error {
-		var tmpTableSQL string
-		var allArgs []any
-		tmpTableSQL = fmt.Sprintf("(select %s from system.statement_info where %s)t", proj, cond)
-		allArgs = append(allArgs, args...)
-		//tmpTable := db.Table(tmpTableSQL, allArgs...)
+	} else {
 		querySelect = db.ToSQL(func(tx *gorm.DB) *gorm.DB {
-			query := tx.Table(tmpTableSQL, allArgs...).
-				Clauses(hints.CommentBefore("SELECT", sqlComment)).Order(order).Offset(int(offset)).Limit(int(limit))
-			if stmt := query.Statement; len(stmt.BuildClauses) == 0 {
-				//stmt.BuildClauses = query
-				//resetBuildClauses := true
-			}
-			callbacks.BuildQuerySQL(query)
-			logger.Info(context.TODO(), "query: %s", query.Statement.SQL.String())
-			return query
+			return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).Select(proj).Where(cond, args...).Order(order).Offset(int(offset)).Limit(int(limit)).Scan(&record)
 		})
-		return nil
-	})
+	}
 
-	// logger.Info(context.TODO(), "count: %d, queryCount: %s", count, queryCount)
+	var eg errgroup.Group
 	eg.Go(func() error {
-		if cu {
-			var tmpTableSQL string
-			var allArgs []any
-			if enableStatementCU {
-				tmpTableSQL = fmt.Sprintf("(select %s from system.statement_info left join (select * from mo_catalog.statement_cu where %s)tmpcu ON system.statement_info.statement_id = tmpcu.statement_id where %s)t", proj, joinCond, cond)
-				allArgs = append(joinArgs, args...)
-			} else {
-				tmpTableSQL = fmt.Sprintf("(select %s from system.statement_info where %s)t", proj, cond)
-				allArgs = append(allArgs, args...)
-			}
-			tmpTable := db.Table(tmpTableSQL, allArgs...)
-			if minCU != nil && *minCU > 0 {
-				queryCount = tmpTable.ToSQL(func(tx *gorm.DB) *gorm.DB {
-					return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Where("cu > ?", *minCU).Count(&count)
-				})
-			}
-		}
-		if queryCount == "" {
+		if cu && minCU != nil && *minCU > 0 {
+			queryCount = tmpTable().ToSQL(func(tx *gorm.DB) *gorm.DB {
+				return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Where("cu > ?", *minCU).Count(&count)
+			})
+		} else {
 			queryCount = db.ToSQL(func(tx *gorm.DB) *gorm.DB {
 				return tx.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(statementInfoDBTable).Where(cond, args...).Count(&count)
 			})
 		}
 		return db.Raw(queryCount).Count(&count).Error
 	})
+	if err := db.Raw(querySelect).Scan(&record).Error; err != nil {
+		eg.Wait()
+		return nil, -1, err
+	}
 
 	if err := eg.Wait(); err != nil {
 		return nil, -1, err
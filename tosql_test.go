@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// the statements of testdata/statements.yaml, both of account sys
+const (
+	fixtureFailedID  = "018eb819-4048-7e69-aaa6-feb99965eb97"
+	fixtureSuccessID = "018eb819-4048-7e69-aaa6-feb99965eb98"
+)
+
+var (
+	fixtureStart = time.Date(2024, 3, 25, 18, 0, 0, 0, time.UTC)
+	fixtureEnd   = time.Date(2024, 3, 25, 19, 0, 0, 0, time.UTC)
+)
+
+// selectFixtures lists the statements of account sys like the query history API does.
+func selectFixtures(t *testing.T, db *gorm.DB, cfg QueryHistoryConfig, req ListQueryHistoryRequest) []StatementInfo {
+	t.Helper()
+	req.StartTime, req.EndTime = fixtureStart, fixtureEnd
+	if err := req.validate(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cond, args, order, limit, offset := generateFilters(&req, sysAccount)
+	joinCond, joinArgs := generateJoinFilters(&req, sysAccount, cfg.ResponseAtExtension)
+	proj, cu := generateProjection(req.CU, cfg.EnableStatementCU, cfg.EnableStatsCU)
+	records, _, err := StatementInfo{Account: sysAccount}.SelectStatements(db, proj, cond, args, order,
+		limit, offset, NonUserRawComment, cu, req.MinCU, joinCond, joinArgs, cfg.EnableStatementCU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func statementIDs(records []StatementInfo) string {
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.StatementId
+	}
+	return strings.Join(ids, ",")
+}
+
+func cuOf(records []StatementInfo) map[string]*float64 {
+	cu := make(map[string]*float64, len(records))
+	for _, r := range records {
+		cu[r.StatementId] = r.CU
+	}
+	return cu
+}
+
+func TestSelectStatements(t *testing.T) {
+	f := StartFakeMO(t, FakeMOOptions{Fixtures: []string{fakeMOFixtures}})
+	statsCU := DefaultQueryHistoryConfig()
+	statsCU.EnableStatsCU = true
+	statementCU := DefaultQueryHistoryConfig()
+	statementCU.EnableStatementCU = true
+	one := uint(1)
+
+	for _, tc := range []struct {
+		name string
+		cfg  QueryHistoryConfig
+		req  ListQueryHistoryRequest
+		want string
+		cu   map[string]float64
+	}{
+		{name: "newest first", cfg: DefaultQueryHistoryConfig(),
+			want: fixtureSuccessID + "," + fixtureFailedID},
+		{name: "oldest first", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{Asc: true},
+			want: fixtureFailedID + "," + fixtureSuccessID},
+		{name: "status", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{Status: failedStatus},
+			want: fixtureFailedID},
+		{name: "keyword", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{Keyword: "select"},
+			want: fixtureSuccessID},
+		{name: "keyword wildcards", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{Keyword: "user%info"}},
+		{name: "keyword underscore", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{Keyword: "test.user_info set"},
+			want: fixtureFailedID},
+		{name: "page", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{PageNumber: 2, PageSize: 1},
+			want: fixtureFailedID},
+		{name: "other user", cfg: DefaultQueryHistoryConfig(), req: ListQueryHistoryRequest{User: "root"}},
+		// v4 stats carry the CU, v3 go through the mo_cu_v1 stub, which is the duration in seconds
+		{name: "stats cu", cfg: statsCU, req: ListQueryHistoryRequest{CU: true},
+			want: fixtureSuccessID + "," + fixtureFailedID,
+			cu:   map[string]float64{fixtureSuccessID: 0.0125, fixtureFailedID: 3}},
+		{name: "stats cu min cu", cfg: statsCU, req: ListQueryHistoryRequest{MinCU: &one},
+			want: fixtureFailedID,
+			cu:   map[string]float64{fixtureFailedID: 3}},
+		// the failed statement has no row in statement_cu
+		{name: "statement cu", cfg: statementCU, req: ListQueryHistoryRequest{CU: true},
+			want: fixtureSuccessID + "," + fixtureFailedID,
+			cu:   map[string]float64{fixtureSuccessID: 0.0125}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			records := selectFixtures(t, f.DB(), tc.cfg, tc.req)
+			if got := statementIDs(records); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+			for id, cu := range cuOf(records) {
+				want, ok := tc.cu[id]
+				switch {
+				case !ok && cu != nil:
+					t.Errorf("%s: cu %v, want none", id, *cu)
+				case ok && (cu == nil || *cu != want):
+					t.Errorf("%s: cu %v, want %v", id, cu, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectByStatementId(t *testing.T) {
+	f := StartFakeMO(t, FakeMOOptions{Fixtures: []string{fakeMOFixtures}})
+	late := fixtureEnd.Add(time.Hour)
+
+	for _, tc := range []struct {
+		name              string
+		id, account       string
+		start, end        *time.Time
+		cu                bool
+		enableStatementCU bool
+		want              string
+		wantCU            *float64
+		err               error
+	}{
+		{name: "found", id: fixtureSuccessID, account: sysAccount,
+			want: "select * from test.user_info;"},
+		{name: "in window", id: fixtureFailedID, account: sysAccount, start: &fixtureStart, end: &fixtureEnd,
+			want: "update test.user_info set name = 'xzxiong' where id = 1;"},
+		{name: "before window", id: fixtureFailedID, account: sysAccount, start: &fixtureEnd, end: &late,
+			err: gorm.ErrRecordNotFound},
+		{name: "other account", id: fixtureSuccessID, account: "acc1", err: gorm.ErrRecordNotFound},
+		{name: "unknown", id: "018eb819-4048-7e69-aaa6-feb99965eb99", account: sysAccount, err: gorm.ErrRecordNotFound},
+		{name: "statement cu", id: fixtureSuccessID, account: sysAccount, start: &fixtureStart, end: &fixtureEnd,
+			cu: true, enableStatementCU: true, want: "select * from test.user_info;", wantCU: ptr(0.0125)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proj, _ := generateProjection(tc.cu, tc.enableStatementCU, false)
+			si := StatementInfo{StatementId: tc.id, Account: tc.account}
+			record, err := si.SelectByStatementId(f.DB(), &proj, tc.start, tc.end, NonUserRawComment, tc.cu, tc.end, tc.enableStatementCU)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("got %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.StatementId != tc.id || record.Statement != tc.want {
+				t.Errorf("got %s %q, want %s %q", record.StatementId, record.Statement, tc.id, tc.want)
+			}
+			if (record.CU == nil) != (tc.wantCU == nil) || (record.CU != nil && *record.CU != *tc.wantCU) {
+				t.Errorf("cu %v, want %v", record.CU, tc.wantCU)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
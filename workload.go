@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/hints"
+)
+
+// workloadGroupCols are the extra dimensions a fingerprint summary can be split by.
+var workloadGroupCols = map[string]string{
+	"user":     "`user`",
+	"database": "`database`",
+}
+
+// workloadSortCols maps the sort keys of FingerprintStatsRequest to result columns.
+var workloadSortCols = map[string]string{
+	"count":        "`count`",
+	"error_rate":   "`error_rate`",
+	"p50":          "`p50`",
+	"p95":          "`p95`",
+	"p99":          "`p99`",
+	"max_duration": "`max_duration`",
+	"rows_read":    "`rows_read`",
+	"bytes_scan":   "`bytes_scan`",
+	"cu":           "`cu`",
+}
+
+type FingerprintStatsRequest struct {
+	// Account limits the summary to one account, empty means all.
+	Account string
+	Start   time.Time
+	End     time.Time
+	// GroupBy optionally adds "user" and/or "database" to the fingerprint.
+	GroupBy []string
+	// SortBy is one of the keys in workloadSortCols, default count.
+	SortBy string
+	Asc    bool
+	Limit  int
+}
+
+// FingerprintStats summarizes the finished statements sharing one fingerprint.
+// Durations are in nanoseconds, like statement_info.duration.
+type FingerprintStats struct {
+	StatementFingerprint string  `json:"statement_fingerprint"`
+	User                 string  `json:"user,omitempty"`
+	Database             string  `json:"database,omitempty"`
+	Count                int64   `json:"count"`
+	Errors               int64   `json:"errors"`
+	ErrorRate            float64 `json:"error_rate"`
+	P50                  uint64  `json:"p50"`
+	P95                  uint64  `json:"p95"`
+	P99                  uint64  `json:"p99"`
+	MaxDuration          uint64  `json:"max_duration"`
+	RowsRead             uint64  `json:"rows_read"`
+	BytesScan            uint64  `json:"bytes_scan"`
+	CU                   float64 `json:"cu"`
+}
+
+// SelectFingerprintStats groups the statements in [Start, End) by fingerprint,
+// the percentiles use the nearest-rank method over a row_number() window.
+func SelectFingerprintStats(db *gorm.DB, req FingerprintStatsRequest, sqlComment string) ([]FingerprintStats, error) {
+	if req.Start.IsZero() || req.End.IsZero() || !req.Start.Before(req.End) {
+		return nil, errors.New("Invalid time range")
+	}
+	groupCols := []string{"statement_fingerprint"}
+	for _, g := range req.GroupBy {
+		col, ok := workloadGroupCols[g]
+		if !ok {
+			return nil, fmt.Errorf("unknown group by: %s", g)
+		}
+		groupCols = append(groupCols, col)
+	}
+	if req.SortBy == "" {
+		req.SortBy = "count"
+	}
+	sortCol, ok := workloadSortCols[req.SortBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort by: %s", req.SortBy)
+	}
+	if !req.Asc {
+		sortCol += " DESC"
+	}
+	group := strings.Join(groupCols, ", ")
+
+	query := selectStatementStats(db, group, req.Account, req.Start, req.End, sqlComment).Order(sortCol)
+	if req.Limit > 0 {
+		query = query.Limit(req.Limit)
+	}
+	records := make([]FingerprintStats, 0)
+	if err := query.Scan(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// selectStatementStats aggregates the finished statements in [start, end) by the group columns,
+// the result has the group columns followed by the columns of FingerprintStats.
+func selectStatementStats(db *gorm.DB, group, account string, start, end time.Time, sqlComment string) *gorm.DB {
+	cond := "status != ? and request_at >= ? and request_at < ?"
+	args := []any{runningStatus, start, end}
+	if account != "" {
+		cond += " and account = ?"
+		args = append(args, account)
+	}
+	tmpTableSQL := fmt.Sprintf("(select %s, duration, status, rows_read, bytes_scan, %s AS cu, "+
+		"row_number() over (partition by %s order by duration) AS rn, count(*) over (partition by %s) AS cnt "+
+		"from system.statement_info where %s)t", group, cuExpr, group, group, cond)
+	proj := fmt.Sprintf("%s, count(*) AS `count`, "+
+		"sum(if(status = '%s', 1, 0)) AS `errors`, sum(if(status = '%s', 1, 0))/count(*) AS `error_rate`, "+
+		"max(if(rn <= ceil(cnt*0.50), duration, 0)) AS `p50`, "+
+		"max(if(rn <= ceil(cnt*0.95), duration, 0)) AS `p95`, "+
+		"max(if(rn <= ceil(cnt*0.99), duration, 0)) AS `p99`, "+
+		"max(duration) AS `max_duration`, sum(rows_read) AS `rows_read`, sum(bytes_scan) AS `bytes_scan`, "+
+		"ifnull(sum(cu), 0) AS `cu`", group, failedStatus, failedStatus)
+	return db.Clauses(hints.CommentBefore("SELECT", sqlComment)).Table(tmpTableSQL, args...).
+		Select(proj).Group(group)
+}